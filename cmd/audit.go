@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vybraan/vygrant/internal/audit"
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/daemon"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the token access audit log",
+	Long:  `Reads the audit log configured with audit_log. Every command also verifies the hash chain.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var auditTailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "Show the most recent audit entries",
+	Run: func(cmd *cobra.Command, args []string) {
+		lines, _ := cmd.Flags().GetInt("lines")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		entries := loadAuditEntries()
+		if lines > 0 && len(entries) > lines {
+			entries = entries[len(entries)-lines:]
+		}
+		printAuditEntries(entries, asJSON)
+	},
+}
+
+var auditQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Filter audit entries by account and time",
+	Run: func(cmd *cobra.Command, args []string) {
+		account, _ := cmd.Flags().GetString("account")
+		sinceFlag, _ := cmd.Flags().GetString("since")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		var since time.Time
+		if sinceFlag != "" {
+			parsed, err := parseSince(sinceFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			since = parsed
+		}
+
+		var matched []audit.Entry
+		for _, entry := range loadAuditEntries() {
+			if account != "" && entry.Account != account {
+				continue
+			}
+			if entry.Time.Before(since) {
+				continue
+			}
+			matched = append(matched, entry)
+		}
+		printAuditEntries(matched, asJSON)
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the hash chain of the audit log",
+	Long:  `Exits with status 2 when an entry was modified, removed or reordered.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries := loadAuditEntries()
+		fmt.Printf("audit log verified (%d entries)\n", len(entries))
+	},
+}
+
+func init() {
+	auditTailCmd.Flags().IntP("lines", "n", 20, "number of entries to show (0 for all)")
+	auditTailCmd.Flags().Bool("json", false, "print raw JSON lines")
+	auditQueryCmd.Flags().String("account", "", "only entries for this account")
+	auditQueryCmd.Flags().String("since", "", "only entries after a duration ago (e.g. 24h) or a time (RFC 3339 or YYYY-MM-DD)")
+	auditQueryCmd.Flags().Bool("json", false, "print raw JSON lines")
+
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditTailCmd)
+	auditCmd.AddCommand(auditQueryCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+}
+
+// loadAuditEntries reads the configured audit log and exits if it cannot be read or
+// its hash chain does not verify.
+func loadAuditEntries() []audit.Entry {
+	cfg, err := config.LoadConfig(daemon.ConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.AuditLog == "" {
+		fmt.Fprintln(os.Stderr, "error: audit_log is not configured")
+		os.Exit(1)
+	}
+	entries, err := audit.Read(cfg.AuditLog)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := audit.Verify(entries); err != nil {
+		fmt.Fprintf(os.Stderr, "error: audit log %s failed verification: %v\n", cfg.AuditLog, err)
+		os.Exit(2)
+	}
+	return entries
+}
+
+func printAuditEntries(entries []audit.Entry, asJSON bool) {
+	for _, entry := range entries {
+		if asJSON {
+			line, _ := json.Marshal(entry)
+			fmt.Println(string(line))
+			continue
+		}
+		account := entry.Account
+		if account == "" {
+			account = "-"
+		}
+		result := entry.Result
+		if entry.Error != "" {
+			result += ": " + entry.Error
+		}
+		caller := entry.Source
+		if entry.Caller != nil {
+			caller = fmt.Sprintf("%s pid=%d uid=%d exe=%s", entry.Source, entry.Caller.PID, entry.Caller.UID, entry.Caller.Exe)
+		}
+		fmt.Printf("%s  %-14s %-12s %s  [%s]\n", entry.Time.Local().Format(time.RFC3339), entry.Event, account, result, caller)
+	}
+}
+
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: %s", value, strings.Join([]string{"use a duration like 24h", "RFC 3339", "or YYYY-MM-DD"}, ", "))
+}
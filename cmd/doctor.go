@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vybraan/vygrant/internal/auth"
+	"github.com/vybraan/vygrant/internal/certgen"
+	"github.com/vybraan/vygrant/internal/client"
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/daemon"
+	"github.com/vybraan/vygrant/internal/storage"
+)
+
+const (
+	doctorTimeout     = 10 * time.Second
+	maxClockSkew      = time.Minute
+	certExpiryWarning = 14 * 24 * time.Hour
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the daemon, configuration, storage and providers",
+	Long: `Checks socket reachability, the configuration, token storage backends, the local
+CA and localhost certificates, the HTTP listeners, and each account's token endpoint
+including clock skew against the provider. Exits non-zero when a check fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		d := &doctor{out: os.Stdout}
+		running := d.checkSocket()
+		cfg := d.checkConfig()
+		d.checkBackends(cfg)
+		ca := d.checkCerts(cfg)
+		if cfg != nil {
+			d.checkListeners(cfg, running, ca)
+			d.checkTokenEndpoints(cfg)
+		}
+		if d.failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+type doctor struct {
+	out    io.Writer
+	failed bool
+}
+
+func (d *doctor) ok(name, format string, args ...any) {
+	fmt.Fprintf(d.out, "[ ok ] %s: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(name, format string, args ...any) {
+	fmt.Fprintf(d.out, "[warn] %s: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) fail(name, format string, args ...any) {
+	d.failed = true
+	fmt.Fprintf(d.out, "[FAIL] %s: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) checkSocket() bool {
+	if _, err := client.SendCommand("info"); err != nil {
+		d.warn("socket", "daemon not reachable at %s: %v", daemon.SocketPath(), err)
+		return false
+	}
+	d.ok("socket", "daemon reachable at %s", daemon.SocketPath())
+	return true
+}
+
+func (d *doctor) checkConfig() *config.Config {
+	cfg, err := daemon.LoadConfig()
+	if err != nil {
+		d.fail("config", "%v", err)
+		return nil
+	}
+	d.ok("config", "%s (%d accounts)", daemon.ConfigPath(), len(cfg.Accounts))
+	return cfg
+}
+
+func (d *doctor) checkBackends(cfg *config.Config) {
+	keyring := storage.KeyringAvailable("")
+	pass := storage.PassAvailable()
+	if keyring {
+		d.ok("keyring", "available")
+	} else {
+		d.warn("keyring", "unavailable")
+	}
+	if pass {
+		d.ok("pass", "installed")
+	} else {
+		d.warn("pass", "not installed")
+	}
+	if cfg != nil && cfg.PersistTokens && !keyring && !pass {
+		d.fail("persistence", "persist_tokens is enabled but neither the keyring nor pass is available; refresh tokens will be lost on restart")
+	}
+}
+
+// checkCerts validates the local CA and leaf certificates and returns the CA, if any.
+func (d *doctor) checkCerts(cfg *config.Config) *x509.Certificate {
+	ca, leaf, err := certgen.LocalCerts()
+	if err != nil {
+		d.fail("certs", "%v", err)
+		return nil
+	}
+	httpsUsed := cfg == nil || daemon.IsListenerEnabled(cfg.HTTPSListen)
+	report := d.fail
+	if !httpsUsed {
+		report = d.warn
+	}
+
+	now := time.Now()
+	for _, item := range []struct {
+		name string
+		cert *x509.Certificate
+	}{{"ca cert", ca}, {"leaf cert", leaf}} {
+		switch {
+		case item.cert == nil:
+			d.warn(item.name, "not generated yet; it is created when the daemon starts with https_listen enabled")
+		case now.After(item.cert.NotAfter):
+			report(item.name, "expired on %s", item.cert.NotAfter.Format(time.RFC3339))
+		case item.cert.NotAfter.Sub(now) < certExpiryWarning:
+			d.warn(item.name, "expires on %s", item.cert.NotAfter.Format(time.RFC3339))
+		default:
+			d.ok(item.name, "valid until %s", item.cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	if ca == nil || leaf == nil {
+		return ca
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "localhost"}); err != nil {
+		report("leaf cert", "not signed by the local CA: %v", err)
+	}
+	system, err := x509.SystemCertPool()
+	if err != nil {
+		d.warn("trust", "cannot load system trust store: %v", err)
+		return ca
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: system, DNSName: "localhost"}); err != nil {
+		d.warn("trust", "local CA is not in the system trust store (browsers using their own store may still trust it); run `vygrant trust`")
+	} else {
+		d.ok("trust", "local CA is trusted by the system")
+	}
+	return ca
+}
+
+// checkListeners probes /readyz on the daemon's listeners when it is running and
+// otherwise checks that the configured ports are free.
+func (d *doctor) checkListeners(cfg *config.Config, running bool, ca *x509.Certificate) {
+	type listener struct {
+		name   string
+		port   string
+		scheme string
+	}
+	listeners := []listener{
+		{"http listener", cfg.HTTPListen, "http"},
+		{"https listener", cfg.HTTPSListen, "https"},
+		{"metrics listener", cfg.MetricsListen, ""},
+		{"proxy listener", cfg.Proxy.Listen, ""},
+	}
+	for _, reverse := range cfg.Proxy.Reverse {
+		listeners = append(listeners, listener{"reverse proxy " + reverse.Upstream, reverse.Listen, ""})
+	}
+
+	roots := x509.NewCertPool()
+	if ca != nil {
+		roots.AddCert(ca)
+	}
+	httpClient := &http.Client{
+		Timeout:   doctorTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	}
+
+	for _, l := range listeners {
+		if !daemon.IsListenerEnabled(l.port) {
+			continue
+		}
+		addr := net.JoinHostPort("localhost", l.port)
+		if !running {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				d.fail(l.name, "port %s is in use: %v", l.port, err)
+				continue
+			}
+			ln.Close()
+			d.ok(l.name, "port %s is free", l.port)
+			continue
+		}
+		if l.scheme == "" {
+			conn, err := net.DialTimeout("tcp", addr, doctorTimeout)
+			if err != nil {
+				d.fail(l.name, "not accepting connections on %s: %v", addr, err)
+				continue
+			}
+			conn.Close()
+			d.ok(l.name, "accepting connections on %s", addr)
+			continue
+		}
+		url := l.scheme + "://" + addr + "/readyz"
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			d.fail(l.name, "%s: %v", url, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			d.fail(l.name, "%s returned %s", url, resp.Status)
+			continue
+		}
+		d.ok(l.name, "%s is ready", url)
+	}
+}
+
+// checkTokenEndpoints checks that every token endpoint answers and compares the
+// provider's Date header with the local clock. Endpoints are probed with HEAD; when
+// that answers 404 or 405, which many providers do for HEAD, an empty POST is sent
+// instead, which a token endpoint rejects with 400 or 401. A 404 or 5xx answer to
+// the POST means token_uri is wrong or the provider is failing.
+func (d *doctor) checkTokenEndpoints(cfg *config.Config) {
+	names := make([]string, 0, len(cfg.Accounts))
+	for name := range cfg.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		check := "account " + name
+		acct := cfg.Accounts[name]
+		// Reach the endpoint the way the daemon does, through the account's proxy
+		// and with its CA file, but without waiting longer than the other checks.
+		settings := config.AccountHTTP(cfg.HTTP, acct)
+		settings.Timeout = min(settings.Timeout, doctorTimeout)
+		httpClient, err := auth.HTTPClient(nil, settings)
+		if err != nil {
+			d.fail(check, "http settings: %v", err)
+			continue
+		}
+		sent := time.Now()
+		resp, err := httpClient.Head(acct.TokenURI)
+		if err == nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed) {
+			resp.Body.Close()
+			sent = time.Now()
+			resp, err = httpClient.PostForm(acct.TokenURI, nil)
+		}
+		if err != nil {
+			d.fail(check, "token endpoint %s unreachable: %v", acct.TokenURI, err)
+			continue
+		}
+		resp.Body.Close()
+		received := time.Now()
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= 500 {
+			d.fail(check, "token endpoint %s answered HTTP %d; check token_uri", acct.TokenURI, resp.StatusCode)
+			continue
+		}
+
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			d.ok(check, "token endpoint reachable (HTTP %d, no Date header)", resp.StatusCode)
+			continue
+		}
+		local := sent.Add(received.Sub(sent) / 2)
+		skew := local.Sub(date).Round(time.Second)
+		if skew < -maxClockSkew || skew > maxClockSkew {
+			d.warn(check, "token endpoint reachable (HTTP %d) but clock skew is %s; tokens may look expired or not yet valid", resp.StatusCode, skew)
+			continue
+		}
+		d.ok(check, "token endpoint reachable (HTTP %d, clock skew %s)", resp.StatusCode, skew)
+	}
+}
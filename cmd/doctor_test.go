@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+func TestDoctorCheckTokenEndpoints(t *testing.T) {
+	// Like many providers, the endpoint refuses HEAD and rejects an empty POST.
+	inSync := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer inSync.Close()
+	skewed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-2*time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer skewed.Close()
+	undated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil
+	}))
+	defer undated.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	wrongPath := httptest.NewServer(http.NotFoundHandler())
+	defer wrongPath.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	// The Date header has second resolution, so the skew is checked to the minute.
+	tests := []struct {
+		tokenURI string
+		want     string
+		failed   bool
+	}{
+		{inSync.URL, "[ ok ] account acct: token endpoint reachable (HTTP 400, clock skew ", false},
+		{skewed.URL, "[warn] account acct: token endpoint reachable (HTTP 200) but clock skew is 2h0m", false},
+		{undated.URL, "[ ok ] account acct: token endpoint reachable (HTTP 200, no Date header)", false},
+		{closed.URL, "[FAIL] account acct: token endpoint " + closed.URL + " unreachable", true},
+		{wrongPath.URL + "/oauth/tokn", "[FAIL] account acct: token endpoint " + wrongPath.URL + "/oauth/tokn answered HTTP 404; check token_uri", true},
+		{failing.URL, "[FAIL] account acct: token endpoint " + failing.URL + " answered HTTP 502; check token_uri", true},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		d := &doctor{out: &out}
+		d.checkTokenEndpoints(&config.Config{Accounts: map[string]*config.Account{
+			"acct": {TokenURI: tt.tokenURI},
+		}})
+		if !strings.HasPrefix(out.String(), tt.want) {
+			t.Errorf("output = %q, want prefix %q", out.String(), tt.want)
+		}
+		if d.failed != tt.failed {
+			t.Errorf("%s: failed = %v, want %v", tt.tokenURI, d.failed, tt.failed)
+		}
+	}
+}
+
+func TestDoctorCheckListenersWithoutDaemon(t *testing.T) {
+	busy, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	_, busyPort, _ := net.SplitHostPort(busy.Addr().String())
+	free := freePort(t)
+
+	var out strings.Builder
+	d := &doctor{out: &out}
+	d.checkListeners(&config.Config{HTTPListen: free, HTTPSListen: "none", MetricsListen: busyPort}, false, nil)
+
+	want := "[ ok ] http listener: port " + free + " is free\n" +
+		"[FAIL] metrics listener: port " + busyPort + " is in use"
+	if !strings.HasPrefix(out.String(), want) || !d.failed {
+		t.Errorf("output = %q, want prefix %q and a failure", out.String(), want)
+	}
+}
+
+func TestDoctorCheckListenersProbesReadyz(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+		failed bool
+	}{
+		{http.StatusOK, "is ready", false},
+		{http.StatusServiceUnavailable, "returned 503 Service Unavailable", true},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/readyz" {
+				t.Errorf("probed %s, want /readyz", r.URL.Path)
+			}
+			w.WriteHeader(tt.status)
+		}))
+		u, _ := url.Parse(server.URL)
+
+		var out strings.Builder
+		d := &doctor{out: &out}
+		d.checkListeners(&config.Config{HTTPListen: u.Port(), HTTPSListen: "none"}, true, nil)
+		server.Close()
+
+		if !strings.Contains(out.String(), tt.want) || d.failed != tt.failed {
+			t.Errorf("status %d: output = %q, failed = %v", tt.status, out.String(), d.failed)
+		}
+	}
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vybraan/vygrant/internal/client"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec --account <name> [--env <VAR>] ... -- <command> [args...]",
+	Short: "Run a command with fresh tokens in its environment",
+	Long: `Fetches the access tokens of the given accounts from the daemon, refreshing them
+when needed, and runs the command with each token exported in an environment variable.
+
+--account and --env are paired in order. When --env is omitted the variable is named
+VYGRANT_TOKEN_<ACCOUNT>. Names must be valid shell variable names and unique. With
+--file the variable holds the path of a private file containing the token instead of
+the token itself.
+
+Signals received by vygrant are forwarded to the command and its exit code is returned.
+With --watch the tokens are polled and, when one rotates, the command is restarted or,
+if --signal is set, sent that signal (token files are rewritten first).`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		accounts, _ := cmd.Flags().GetStringArray("account")
+		envNames, _ := cmd.Flags().GetStringArray("env")
+		useFiles, _ := cmd.Flags().GetBool("file")
+		watch, _ := cmd.Flags().GetBool("watch")
+		interval, _ := cmd.Flags().GetDuration("watch-interval")
+		signalName, _ := cmd.Flags().GetString("signal")
+
+		if err := runExec(accounts, envNames, useFiles, watch, interval, signalName, args); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				os.Exit(exitCode(exitErr))
+			}
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	execCmd.Flags().StringArray("account", nil, "account whose token is exported (repeatable)")
+	execCmd.Flags().StringArray("env", nil, "environment variable for the matching --account (repeatable)")
+	execCmd.Flags().Bool("file", false, "export the path of a private token file instead of the token")
+	execCmd.Flags().Bool("watch", false, "restart or signal the command when a token rotates")
+	execCmd.Flags().Duration("watch-interval", time.Minute, "how often tokens are checked with --watch")
+	execCmd.Flags().String("signal", "", "signal sent on token rotation instead of restarting (e.g. HUP)")
+	execCmd.MarkFlagRequired("account")
+	rootCmd.AddCommand(execCmd)
+}
+
+type execBinding struct {
+	account string
+	env     string
+	token   string
+	file    string
+}
+
+var (
+	envNameSanitizer = regexp.MustCompile(`[^A-Z0-9_]`)
+	envNamePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// parseBindings pairs accounts with envNames in order, naming the variable of each
+// account after it when envNames is empty. Variable names must be valid shell names
+// and unique.
+func parseBindings(accounts, envNames []string) ([]*execBinding, error) {
+	if len(envNames) != 0 && len(envNames) != len(accounts) {
+		return nil, fmt.Errorf("got %d --env values for %d --account values", len(envNames), len(accounts))
+	}
+	bindings := make([]*execBinding, len(accounts))
+	seen := make(map[string]string, len(accounts))
+	for i, account := range accounts {
+		env := "VYGRANT_TOKEN_" + envNameSanitizer.ReplaceAllString(strings.ToUpper(account), "_")
+		if len(envNames) != 0 {
+			env = envNames[i]
+		}
+		if !envNamePattern.MatchString(env) {
+			return nil, fmt.Errorf("invalid environment variable name %q for account '%s'", env, account)
+		}
+		if other, ok := seen[env]; ok {
+			return nil, fmt.Errorf("accounts '%s' and '%s' both use environment variable %s", other, account, env)
+		}
+		seen[env] = account
+		bindings[i] = &execBinding{account: account, env: env}
+	}
+	return bindings, nil
+}
+
+func runExec(accounts, envNames []string, useFiles, watch bool, interval time.Duration, signalName string, args []string) error {
+	bindings, err := parseBindings(accounts, envNames)
+	if err != nil {
+		return err
+	}
+	if watch && interval <= 0 {
+		return fmt.Errorf("--watch-interval must be positive")
+	}
+	var reloadSignal os.Signal
+	if signalName != "" {
+		sig, err := parseSignal(signalName)
+		if err != nil {
+			return err
+		}
+		reloadSignal = sig
+	}
+
+	var tokenDir string
+	if useFiles {
+		dir, err := os.MkdirTemp("", "vygrant-exec-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		tokenDir = dir
+	}
+
+	if _, err := refreshBindings(bindings, tokenDir); err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	var ticks <-chan time.Time
+	if watch {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		child, err := startChild(args, bindings)
+		if err != nil {
+			return err
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- child.Wait()
+		}()
+
+		restart := false
+		for !restart {
+			select {
+			case err := <-done:
+				return err
+			case sig := <-signals:
+				child.Process.Signal(sig)
+			case <-ticks:
+				changed, err := refreshBindings(bindings, tokenDir)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "vygrant: token check failed: %v\n", err)
+					continue
+				}
+				if !changed {
+					continue
+				}
+				if reloadSignal != nil {
+					child.Process.Signal(reloadSignal)
+					continue
+				}
+				stopChild(child, done)
+				restart = true
+			}
+		}
+	}
+}
+
+// refreshBindings fetches the current token of every binding, rewriting token files
+// when tokenDir is set. It reports whether any token differs from the previous one.
+func refreshBindings(bindings []*execBinding, tokenDir string) (bool, error) {
+	changed := false
+	for _, b := range bindings {
+		token, err := fetchToken(b.account)
+		if err != nil {
+			return false, err
+		}
+		if token == b.token {
+			continue
+		}
+		changed = true
+		b.token = token
+		if tokenDir != "" {
+			path, err := writeTokenFile(tokenDir, b)
+			if err != nil {
+				return false, err
+			}
+			b.file = path
+		}
+	}
+	return changed, nil
+}
+
+func fetchToken(account string) (string, error) {
+	output, err := client.SendCommand("get-token " + account)
+	if err != nil {
+		return "", err
+	}
+	if msg, ok := strings.CutPrefix(output, "ERROR: "); ok {
+		return "", errors.New(msg)
+	}
+	if output == "" {
+		return "", fmt.Errorf("daemon returned an empty token for '%s'", account)
+	}
+	return output, nil
+}
+
+// writeTokenFile atomically replaces the token file of b, readable only by the owner.
+func writeTokenFile(dir string, b *execBinding) (string, error) {
+	path := filepath.Join(dir, b.env)
+	tmp, err := os.CreateTemp(dir, b.env+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if _, err := tmp.WriteString(b.token); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+func startChild(args []string, bindings []*execBinding) (*exec.Cmd, error) {
+	child := exec.Command(args[0], args[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	child.Env = os.Environ()
+	for _, b := range bindings {
+		value := b.token
+		if b.file != "" {
+			value = b.file
+		}
+		child.Env = append(child.Env, b.env+"="+value)
+	}
+	if err := child.Start(); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// stopChild asks the child to terminate and kills it if it is still running after 10s.
+func stopChild(child *exec.Cmd, done <-chan error) {
+	if err := child.Process.Signal(terminateSignal); err != nil {
+		child.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		child.Process.Kill()
+		<-done
+	}
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBindings(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts []string
+		envNames []string
+		want     []string
+		err      string
+	}{
+		{name: "default names", accounts: []string{"myapp", "work-mail"}, want: []string{"VYGRANT_TOKEN_MYAPP", "VYGRANT_TOKEN_WORK_MAIL"}},
+		{name: "identity and scope set", accounts: []string{"myapp/alice", "myapp#read"}, want: []string{"VYGRANT_TOKEN_MYAPP_ALICE", "VYGRANT_TOKEN_MYAPP_READ"}},
+		{name: "explicit names", accounts: []string{"a", "b"}, envNames: []string{"GH_TOKEN", "_b2"}, want: []string{"GH_TOKEN", "_b2"}},
+		{name: "count mismatch", accounts: []string{"a", "b"}, envNames: []string{"A"}, err: "got 1 --env values for 2 --account values"},
+		{name: "leading digit", accounts: []string{"a"}, envNames: []string{"1TOKEN"}, err: "invalid environment variable name"},
+		{name: "equals sign", accounts: []string{"a"}, envNames: []string{"A=B"}, err: "invalid environment variable name"},
+		{name: "empty name", accounts: []string{"a"}, envNames: []string{""}, err: "invalid environment variable name"},
+		{name: "dash", accounts: []string{"a"}, envNames: []string{"MY-TOKEN"}, err: "invalid environment variable name"},
+		{name: "duplicate explicit", accounts: []string{"a", "b"}, envNames: []string{"TOKEN", "TOKEN"}, err: "both use environment variable TOKEN"},
+		{name: "duplicate default", accounts: []string{"my-app", "my_app"}, err: "both use environment variable VYGRANT_TOKEN_MY_APP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindings, err := parseBindings(tt.accounts, tt.envNames)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bindings) != len(tt.want) {
+				t.Fatalf("got %d bindings, want %d", len(bindings), len(tt.want))
+			}
+			for i, b := range bindings {
+				if b.account != tt.accounts[i] || b.env != tt.want[i] {
+					t.Errorf("binding %d = %s=%s, want %s=%s", i, b.account, b.env, tt.accounts[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
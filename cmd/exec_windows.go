@@ -0,0 +1,21 @@
+//go:build windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+var terminateSignal os.Signal = os.Kill
+
+func parseSignal(name string) (os.Signal, error) {
+	return nil, fmt.Errorf("--signal is not supported on windows")
+}
+
+func exitCode(err *exec.ExitError) int {
+	return err.ExitCode()
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var proxyEnvCmd = &cobra.Command{
+	Use:   "proxy-env",
+	Short: "Print the environment for using the token proxies",
+	Long: `Prints shell assignments of http_proxy, HTTP_PROXY and VYGRANT_PROXY_SECRET with
+	the secret the proxies require. The secret changes every time the daemon starts.
+
+	eval "$(vygrant proxy-env)"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runClientCommand("proxy-env")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(proxyEnvCmd)
+}
@@ -1,19 +1,75 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/vybraan/vygrant/internal/client"
+	"github.com/vybraan/vygrant/internal/daemon"
 )
 
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show authentication status for all configured accounts",
-	Long: `Checks the authentication tokens for all configured accounts 
-	and reports whether they are valid, expired, or missing.`,
+	Long: `Reports for each account whether an access token is present and when it expires,
+whether a refresh token is present and which backend holds it, the last refresh
+and its result, and the grant type.
+
+Exits with status 2 when any reported account is unhealthy: not authenticated,
+expired without a refresh token, or failing to refresh.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		runClientCommand("status")
+		account, _ := cmd.Flags().GetString("account")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if account != "" {
+			account = tokenKey(cmd, account)
+		}
+
+		command := "status --json"
+		if account != "" {
+			command += " " + account
+		}
+		output, err := client.SendCommand(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if msg, ok := strings.CutPrefix(output, "ERROR: "); ok {
+			fmt.Fprintf(os.Stderr, "error: %s\n", msg)
+			os.Exit(1)
+		}
+		var statuses []daemon.AccountStatus
+		if err := json.Unmarshal([]byte(output), &statuses); err != nil {
+			fmt.Fprintf(os.Stderr, "error: unexpected status response: %v\n", err)
+			os.Exit(1)
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(statuses)
+		} else {
+			fmt.Println(daemon.FormatStatus(statuses))
+		}
+		os.Exit(statusExitCode(statuses))
 	},
 }
 
+// statusExitCode is 2 when any of statuses is unhealthy and 0 otherwise.
+func statusExitCode(statuses []daemon.AccountStatus) int {
+	for _, status := range statuses {
+		if !status.Healthy {
+			return 2
+		}
+	}
+	return 0
+}
+
 func init() {
+	statusCmd.Flags().String("account", "", "only report this account and its identities")
+	statusCmd.Flags().String("identity", "", "only report this identity of --account")
+	statusCmd.Flags().Bool("json", false, "print JSON")
 	rootCmd.AddCommand(statusCmd)
 }
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/vybraan/vygrant/internal/daemon"
+)
+
+func TestStatusExitCode(t *testing.T) {
+	healthy := daemon.AccountStatus{Account: "a", Healthy: true}
+	unhealthy := daemon.AccountStatus{Account: "b", Problem: "not authenticated"}
+	tests := []struct {
+		name     string
+		statuses []daemon.AccountStatus
+		want     int
+	}{
+		{"no accounts", nil, 0},
+		{"all healthy", []daemon.AccountStatus{healthy, healthy}, 0},
+		{"one unhealthy", []daemon.AccountStatus{healthy, unhealthy}, 2},
+	}
+	for _, tt := range tests {
+		if got := statusExitCode(tt.statuses); got != tt.want {
+			t.Errorf("%s: statusExitCode = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,15 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/vybraan/vygrant/internal/browser"
+	"github.com/vybraan/vygrant/internal/client"
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/daemon"
 )
 
 var tokenCmd = &cobra.Command{
@@ -16,11 +24,21 @@ var tokenCmd = &cobra.Command{
 var getTokenCmd = &cobra.Command{
 	Use:   "get [account_name]",
 	Short: "Get a specific token",
-	Long:  `Retrieves and displays the token for a specified account.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Retrieves and displays the token for a specified account. With --wait, an
+account that needs authentication is not an error: the daemon asks for a login and
+returns the token once it completes, or fails after the timeout.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		accountName := args[0]
-		runClientCommand("get-token " + accountName)
+		accountName := tokenKey(cmd, args[0])
+		command := "get-token " + accountName
+		if cmd.Flags().Changed("wait") {
+			wait, _ := cmd.Flags().GetDuration("wait")
+			command += " --wait"
+			if wait > 0 {
+				command += "=" + wait.String()
+			}
+		}
+		runClientCommand(command)
 	},
 }
 
@@ -30,7 +48,7 @@ var deleteTokenCmd = &cobra.Command{
 	Long:  `Deletes the token associated with a specified account.`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		accountName := args[0]
+		accountName := tokenKey(cmd, args[0])
 		runClientCommand("delete-token " + accountName)
 	},
 }
@@ -41,17 +59,81 @@ var refreshTokenCmd = &cobra.Command{
 	Long:  `Refreshes the token for the specified account.`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		accountName := args[0]
+		accountName := tokenKey(cmd, args[0])
 		runClientCommand("refresh-token " + accountName)
 	},
 }
 
+var loginTokenCmd = &cobra.Command{
+	Use:   "login [account_name]",
+	Short: "Authenticate an account in the browser",
+	Long: `Opens the authorization page of the account in the browser and waits until the
+daemon receives the callback, then prints the new access token. The browser is the
+"browser" command from the config, else $BROWSER, else the system URL handler.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		accountName := tokenKey(cmd, args[0])
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		noBrowser, _ := cmd.Flags().GetBool("no-browser")
+
+		command := "login " + accountName
+		if timeout > 0 {
+			command += " " + timeout.String()
+		}
+		err := client.Subscribe(command, func(line string) error {
+			url, ok := strings.CutPrefix(line, "URL ")
+			if !ok {
+				fmt.Println(line)
+				return nil
+			}
+			if noBrowser {
+				fmt.Fprintf(os.Stderr, "Open this URL to authenticate '%s':\n  %s\n", accountName, url)
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Opening the browser to authenticate '%s'. If it does not open, visit:\n  %s\n", accountName, url)
+			if err := browser.Open(browserCommand(), url); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: could not open the browser: %v\n", err)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// tokenKey returns the token key of account and the --identity and --scopes flags
+// of cmd.
+func tokenKey(cmd *cobra.Command, account string) string {
+	identity, _ := cmd.Flags().GetString("identity")
+	key := config.TokenKey(account, identity)
+	if set, _ := cmd.Flags().GetString("scopes"); set != "" {
+		key += config.ScopeSeparator + set
+	}
+	return key
+}
+
+// browserCommand returns the browser configured in the config file, if it can be
+// read.
+func browserCommand() string {
+	cfg, err := config.LoadConfig(daemon.ConfigPath())
+	if err != nil {
+		return ""
+	}
+	return cfg.Browser
+}
+
 var dumpTokenCmd = &cobra.Command{
 	Use:   "dump",
 	Short: "Dump token state to stdout (sensitive)",
 	Long:  "Dumps the current token state to stdout. Treat this output as sensitive and encrypt it.",
 	Run: func(cmd *cobra.Command, args []string) {
-		runClientCommand("dump-tokens")
+		command := "dump-tokens"
+		if account, _ := cmd.Flags().GetString("account"); account != "" {
+			command += " " + account
+		}
+		runClientCommand(command)
 	},
 }
 
@@ -65,11 +147,23 @@ var restoreTokenCmd = &cobra.Command{
 }
 
 func init() {
+	getTokenCmd.Flags().Duration("wait", 0, "wait for an interactive login if needed, up to the timeout (default login_timeout, 5m)")
+	getTokenCmd.Flags().Lookup("wait").NoOptDefVal = "0s"
+	loginTokenCmd.Flags().Duration("timeout", 0, "how long to wait for the callback (default login_timeout, 5m)")
+	loginTokenCmd.Flags().Bool("no-browser", false, "only print the URL to open")
+
+	for _, c := range []*cobra.Command{getTokenCmd, deleteTokenCmd, refreshTokenCmd, loginTokenCmd} {
+		c.Flags().String("identity", "", "use this identity of the account")
+		c.Flags().String("scopes", "", "use this scope set of the account")
+	}
+	dumpTokenCmd.Flags().String("account", "", "only dump this account and its identities")
+
 	rootCmd.AddCommand(tokenCmd)
 
 	tokenCmd.AddCommand(getTokenCmd)
 	tokenCmd.AddCommand(deleteTokenCmd)
 	tokenCmd.AddCommand(refreshTokenCmd)
+	tokenCmd.AddCommand(loginTokenCmd)
 	tokenCmd.AddCommand(dumpTokenCmd)
 	tokenCmd.AddCommand(restoreTokenCmd)
 }
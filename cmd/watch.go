@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vybraan/vygrant/internal/client"
+	"github.com/vybraan/vygrant/internal/daemon"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Stream daemon events",
+	Long: `Prints token, refresh, authentication and configuration events as they happen,
+until interrupted. With --json every event is printed as one JSON line.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		account, _ := cmd.Flags().GetString("account")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		command := "subscribe"
+		if account != "" {
+			command += " " + account
+		}
+		err := client.Subscribe(command, func(line string) error {
+			if asJSON {
+				fmt.Println(line)
+				return nil
+			}
+			var event daemon.Event
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				return fmt.Errorf("unexpected event: %w", err)
+			}
+			fmt.Println(formatEvent(event))
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func formatEvent(e daemon.Event) string {
+	line := e.Time.Local().Format(time.TimeOnly) + " " + e.Type
+	if e.Account != "" {
+		line += " " + e.Account
+	}
+	if e.Expiry != nil {
+		line += " expires " + e.Expiry.Local().Format(time.DateTime)
+	}
+	if e.Kind != "" {
+		line += " (" + e.Kind + ")"
+	}
+	if e.Error != "" {
+		line += ": " + e.Error
+	}
+	return line
+}
+
+func init() {
+	watchCmd.Flags().String("account", "", "only show events for this account")
+	watchCmd.Flags().Bool("json", false, "print events as JSON lines")
+	rootCmd.AddCommand(watchCmd)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+)
+
+// readinessProbeAccount is the key /readyz looks up. A scope separator followed by
+// no scope set name is rejected in configuration, so it never names a real token.
+const readinessProbeAccount = "vygrant-readiness-probe" + config.ScopeSeparator
+
+// healthz reports that the HTTP listener is serving.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports whether the token store answers lookups. A missing token is a
+// healthy answer; any other error (locked keyring, failing pass) is not.
+func readyz(tokenStore storage.TokenStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if _, err := tokenStore.Get(readinessProbeAccount); err != nil && !errors.Is(err, os.ErrNotExist) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "token store unavailable: %v\n", err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+// failingStore fails every lookup, like a locked keyring.
+type failingStore struct {
+	storage.TokenStore
+	lookups []string
+}
+
+func (s *failingStore) Get(account string) (*oauth2.Token, error) {
+	s.lookups = append(s.lookups, account)
+	return nil, errors.New("keyring is locked")
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Router(storage.NewMemoryStore(), nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok\n" {
+		t.Errorf("/healthz = %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	store := &failingStore{}
+	tests := []struct {
+		name  string
+		store storage.TokenStore
+		code  int
+		body  string
+	}{
+		{"missing probe token", storage.NewMemoryStore(), http.StatusOK, "ok\n"},
+		{"failing store", store, http.StatusServiceUnavailable, "token store unavailable: keyring is locked\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Router(tt.store, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.code || rec.Body.String() != tt.body {
+				t.Errorf("/readyz = %d %q, want %d %q", rec.Code, rec.Body.String(), tt.code, tt.body)
+			}
+		})
+	}
+	if len(store.lookups) != 1 || store.lookups[0] != readinessProbeAccount {
+		t.Errorf("lookups = %q, want the probe key", store.lookups)
+	}
+}
+
+func TestReadinessProbeKeyIsNotAnAccount(t *testing.T) {
+	base, set := config.SplitScopeKey(readinessProbeAccount)
+	if !strings.HasSuffix(readinessProbeAccount, config.ScopeSeparator) || set != "" || base == readinessProbeAccount {
+		t.Errorf("probe key %q splits into %q and %q; it must end in an empty scope set", readinessProbeAccount, base, set)
+	}
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"golang.org/x/oauth2"
+)
+
+// TokenFunc returns a usable access token for account. When force is true the
+// token is refreshed even if the stored one has not expired yet.
+type TokenFunc func(account string, force bool) (*oauth2.Token, error)
+
+// ProxyUser is the user name of the proxy credentials. Only the password, the
+// secret the daemon creates at start, is checked.
+const ProxyUser = "vygrant"
+
+// ForwardProxy returns an HTTP forward proxy handler. Requests whose host and path
+// match one of rules get an "Authorization: Bearer" header for the rule's account;
+// all other requests are forwarded untouched. CONNECT tunnels are refused because
+// headers cannot be added to TLS traffic, so every request is plain http and only
+// loopback hosts get a token; use a reverse proxy for https upstreams.
+//
+// Requests must carry secret as the password of their Proxy-Authorization. Requests
+// from browsers are refused, and since only absolute-form requests are proxied, a
+// page that rebinds its own host name to localhost cannot reach it either.
+func ForwardProxy(rules []config.ProxyRule, tokens TokenFunc, secret string) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = pr.In.URL
+			pr.Out.Host = pr.In.Host
+		},
+		Transport: &bearerTransport{
+			base:   proxyBaseTransport(),
+			tokens: tokens,
+			account: func(r *http.Request) string {
+				return matchProxyRule(rules, r.URL)
+			},
+		},
+		ErrorHandler: proxyErrorHandler,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowProxyRequest(w, r, secret) {
+			return
+		}
+		if r.Method == http.MethodConnect {
+			http.Error(w, "CONNECT is not supported; use a reverse proxy for https upstreams", http.StatusMethodNotAllowed)
+			return
+		}
+		if !r.URL.IsAbs() {
+			http.Error(w, "proxy requests must use an absolute URL", http.StatusBadRequest)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// ReverseProxy returns a handler that forwards every request to upstream with an
+// "Authorization: Bearer" header for account.
+//
+// Requests must carry secret, either as the password of their Proxy-Authorization or
+// as the first path segment, which is removed, and must name localhost as their
+// host. Requests from browsers are refused.
+func ReverseProxy(upstream *url.URL, account string, tokens TokenFunc, secret string) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstream)
+			pr.SetXForwarded()
+		},
+		Transport: &bearerTransport{
+			base:   proxyBaseTransport(),
+			tokens: tokens,
+			account: func(*http.Request) string {
+				return account
+			},
+		},
+		ErrorHandler: proxyErrorHandler,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLocalHost(r.Host) {
+			http.Error(w, "requests must be addressed to localhost", http.StatusForbidden)
+			return
+		}
+		if rest, ok := cutSecretPath(r.URL.Path, secret); ok {
+			r.URL.Path = rest
+			r.URL.RawPath = ""
+			r.Header.Set("Proxy-Authorization", proxyAuthorization(secret))
+		}
+		if !allowProxyRequest(w, r, secret) {
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// allowProxyRequest answers requests that do not carry secret or come from a
+// browser, and reports whether r may be proxied. Proxy-Authorization is a hop-by-hop
+// header, so it is not forwarded.
+func allowProxyRequest(w http.ResponseWriter, r *http.Request, secret string) bool {
+	// Browsers send these with every request, including cross-site ones that a
+	// page makes to localhost; the command line clients the proxies serve do not.
+	if r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != "" || r.Header.Get("Sec-Fetch-Mode") != "" {
+		http.Error(w, "browser requests are not proxied", http.StatusForbidden)
+		return false
+	}
+	_, password, ok := parseBasicAuth(r.Header.Get("Proxy-Authorization"))
+	if !ok || secret == "" || subtle.ConstantTimeCompare([]byte(password), []byte(secret)) != 1 {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="vygrant"`)
+		http.Error(w, "proxy credentials required; see `vygrant proxy-env`", http.StatusProxyAuthRequired)
+		return false
+	}
+	return true
+}
+
+// parseBasicAuth parses the value of a Basic Proxy-Authorization header.
+func parseBasicAuth(header string) (user, password string, ok bool) {
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func proxyAuthorization(secret string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(ProxyUser+":"+secret))
+}
+
+// cutSecretPath removes secret as the first segment of path.
+func cutSecretPath(path, secret string) (string, bool) {
+	if secret == "" {
+		return path, false
+	}
+	rest, ok := strings.CutPrefix(path, "/"+secret)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return path, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// isLocalHost reports whether the Host header names the loopback interface, which
+// a page that rebinds its own host name to 127.0.0.1 cannot send.
+func isLocalHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.EqualFold(host, "localhost") || host == "127.0.0.1" || host == "::1"
+}
+
+// matchProxyRule returns the account of the first rule matching u, or "" when none does.
+// A rule host matches case-insensitively, with or without the port; a leading "*."
+// matches any subdomain. An empty path prefix matches every path. Plain http URLs
+// only match when their host is a loopback host, so a token never crosses the
+// network in cleartext.
+func matchProxyRule(rules []config.ProxyRule, u *url.URL) string {
+	if u.Scheme != "https" && !IsLoopbackHost(u.Hostname()) {
+		return ""
+	}
+	for _, rule := range rules {
+		if !matchHost(rule.Host, u) {
+			continue
+		}
+		if rule.PathPrefix != "" && !strings.HasPrefix(u.Path, rule.PathPrefix) {
+			continue
+		}
+		return rule.Account
+	}
+	return ""
+}
+
+// IsLoopbackHost reports whether host, without a port, is localhost, a subdomain of
+// localhost or a loopback address.
+func IsLoopbackHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func matchHost(pattern string, u *url.URL) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host := strings.ToLower(u.Host)
+	hostname := strings.ToLower(u.Hostname())
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(hostname, "."+suffix)
+	}
+	return pattern == host || pattern == hostname
+}
+
+type bearerTransport struct {
+	base    http.RoundTripper
+	tokens  TokenFunc
+	account func(*http.Request) string
+}
+
+// RoundTrip adds the bearer token of the matching account and, if the upstream
+// answers 401, refreshes the token once and replays the request.
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	account := t.account(req)
+	if account == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if err := bufferBody(req); err != nil {
+		return nil, err
+	}
+
+	token, err := t.tokens(account, false)
+	if err != nil {
+		return nil, &proxyTokenError{account: account, err: err}
+	}
+	resp, err := t.base.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	token, err = t.tokens(account, true)
+	if err != nil {
+		slog.Warn("proxy forced refresh failed", "account", account, "error", err)
+		return resp, nil
+	}
+	retry := withBearer(req, token)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+func withBearer(req *http.Request, token *oauth2.Token) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return out
+}
+
+// bufferBody makes the request body replayable so it can be resent after a refresh.
+func bufferBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil
+}
+
+type proxyTokenError struct {
+	account string
+	err     error
+}
+
+func (e *proxyTokenError) Error() string {
+	return "no usable token for account '" + e.account + "': " + e.err.Error()
+}
+
+func (e *proxyTokenError) Unwrap() error {
+	return e.err
+}
+
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	slog.Warn("proxy request failed", "method", r.Method, "url", r.URL.Redacted(), "error", err)
+	var tokenErr *proxyTokenError
+	if errors.As(err, &tokenErr) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, "upstream request failed", http.StatusBadGateway)
+}
+
+// proxyBaseTransport is the default transport without environment proxies, so the
+// daemon never routes its own proxied requests back through itself.
+func proxyBaseTransport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	return transport
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"golang.org/x/oauth2"
+)
+
+const testSecret = "s3cret"
+
+func TestMatchProxyRule(t *testing.T) {
+	rules := []config.ProxyRule{
+		{Host: "localhost", PathPrefix: "/v1/", Account: "v1"},
+		{Host: "localhost", Account: "api"},
+		{Host: "*.localhost", Account: "wildcard"},
+		{Host: "127.0.0.1:8080", Account: "port"},
+		{Host: "api.example.com", Account: "remote"},
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://localhost/v1/users", "v1"},
+		{"http://localhost/v2/users", "api"},
+		{"http://LOCALHOST:8443/v1/users", "v1"},
+		{"http://a.localhost/", "wildcard"},
+		{"http://a.b.localhost/", "wildcard"},
+		{"http://127.0.0.1:8080/", "port"},
+		{"http://127.0.0.1:9090/", ""},
+		{"http://[::1]/", ""},
+		// Tokens are never sent to other hosts over plain http.
+		{"http://api.example.com/", ""},
+		{"https://api.example.com/", "remote"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchProxyRule(rules, u); got != tt.want {
+			t.Errorf("matchProxyRule(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// tokenCounter hands out "stale" until a forced refresh, then "fresh".
+type tokenCounter struct {
+	forced int
+}
+
+func (c *tokenCounter) token(account string, force bool) (*oauth2.Token, error) {
+	if force {
+		c.forced++
+		return &oauth2.Token{AccessToken: "fresh"}, nil
+	}
+	if c.forced > 0 {
+		return &oauth2.Token{AccessToken: "fresh"}, nil
+	}
+	return &oauth2.Token{AccessToken: "stale"}, nil
+}
+
+func TestReverseProxyRetriesAfterForcedRefresh(t *testing.T) {
+	var bodies []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Error("proxy credentials were forwarded upstream")
+		}
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "ok "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	tokens := &tokenCounter{}
+	proxy := httptest.NewServer(ReverseProxy(target, "myapp", tokens.token, testSecret))
+	defer proxy.Close()
+
+	resp, err := http.Post(localURL(proxy)+"/"+testSecret+"/items", "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok /items" {
+		t.Fatalf("response = %d %q, want 200 %q", resp.StatusCode, body, "ok /items")
+	}
+	if tokens.forced != 1 {
+		t.Errorf("forced refreshes = %d, want 1", tokens.forced)
+	}
+	if len(bodies) != 2 || bodies[1] != "payload" {
+		t.Errorf("upstream bodies = %q, want the payload replayed", bodies)
+	}
+}
+
+func TestProxiesRejectUnauthenticatedAndBrowserRequests(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+	tokens := (&tokenCounter{}).token
+
+	reverse := ReverseProxy(target, "myapp", tokens, testSecret)
+	forward := ForwardProxy(nil, tokens, testSecret)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		target  string
+		host    string
+		header  http.Header
+		want    int
+	}{
+		{"reverse with secret path", reverse, "/" + testSecret + "/", "localhost:9000", nil, http.StatusOK},
+		{"reverse with credentials", reverse, "/", "127.0.0.1:9000", authHeader(testSecret), http.StatusOK},
+		{"reverse without secret", reverse, "/", "localhost:9000", nil, http.StatusProxyAuthRequired},
+		{"reverse with wrong secret", reverse, "/", "localhost", authHeader("guess"), http.StatusProxyAuthRequired},
+		{"reverse secret prefix only", reverse, "/" + testSecret + "x/", "localhost", nil, http.StatusProxyAuthRequired},
+		{"reverse rebound host", reverse, "/" + testSecret + "/", "evil.example:9000", nil, http.StatusForbidden},
+		{"reverse from browser", reverse, "/" + testSecret + "/", "localhost", http.Header{"Origin": {"http://evil.example"}}, http.StatusForbidden},
+		{"reverse fetch metadata", reverse, "/" + testSecret + "/", "localhost", http.Header{"Sec-Fetch-Site": {"cross-site"}}, http.StatusForbidden},
+		{"forward with credentials", forward, upstream.URL + "/", "", authHeader(testSecret), http.StatusOK},
+		{"forward without credentials", forward, upstream.URL + "/", "", nil, http.StatusProxyAuthRequired},
+		{"forward from browser", forward, upstream.URL + "/", "", withHeader(authHeader(testSecret), "Origin", "null"), http.StatusForbidden},
+		{"forward origin-form", forward, "/", "localhost:8888", authHeader(testSecret), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestIsLoopbackHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"localhost":         true,
+		"api.LOCALHOST":     true,
+		"127.0.0.1":         true,
+		"127.1.2.3":         true,
+		"::1":               true,
+		"[::1]":             true,
+		"":                  false,
+		"localhost.example": false,
+		"example.com":       false,
+		"10.0.0.1":          false,
+	} {
+		if got := IsLoopbackHost(host); got != want {
+			t.Errorf("IsLoopbackHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestForwardProxyKeepsTokensOffCleartextRemoteHosts(t *testing.T) {
+	var authorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	var requested []string
+	tokens := func(account string, force bool) (*oauth2.Token, error) {
+		requested = append(requested, account)
+		return &oauth2.Token{AccessToken: "token"}, nil
+	}
+	rules := []config.ProxyRule{{Host: "127.0.0.1", Account: "local"}, {Host: "*.invalid", Account: "remote"}}
+	forward := ForwardProxy(rules, tokens, testSecret)
+
+	for _, target := range []string{upstream.URL + "/users", "http://api.invalid/users"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Proxy-Authorization", proxyAuthorization(testSecret))
+		forward.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if authorization != "Bearer token" {
+		t.Errorf("loopback upstream Authorization = %q, want the token", authorization)
+	}
+	if len(requested) != 1 || requested[0] != "local" {
+		t.Errorf("tokens requested for %q, want only local", requested)
+	}
+}
+
+func TestIsLocalHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"localhost":       true,
+		"LOCALHOST:8889":  true,
+		"127.0.0.1":       true,
+		"127.0.0.1:8889":  true,
+		"[::1]:8889":      true,
+		"localhost.evil":  false,
+		"127.0.0.2:8889":  false,
+		"rebound.example": false,
+		"":                false,
+	} {
+		if got := isLocalHost(host); got != want {
+			t.Errorf("isLocalHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func authHeader(secret string) http.Header {
+	return http.Header{"Proxy-Authorization": {proxyAuthorization(secret)}}
+}
+
+func withHeader(h http.Header, name, value string) http.Header {
+	h.Set(name, value)
+	return h
+}
+
+// localURL addresses server by name, as the reverse proxy only answers localhost.
+func localURL(server *httptest.Server) string {
+	return strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+}
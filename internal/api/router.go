@@ -8,14 +8,17 @@ import (
 	"github.com/vybraan/vygrant/internal/storage"
 )
 
-// Router creates an HTTP router configured with routes for the OAuth callback (GET "/")
-// and authentication initiation (GET "/auth"). The OAuth callback handler is provided the
-// given tokenStore and httpClient. It returns the configured http.Handler.
+// Router creates an HTTP router configured with routes for the OAuth callback (GET "/"),
+// authentication initiation (GET "/auth") and the health checks (GET "/healthz" and
+// GET "/readyz"). The OAuth callback handler is provided the given tokenStore and
+// httpClient. It returns the configured http.Handler.
 func Router(tokenStore storage.TokenStore, httpClient *http.Client) http.Handler {
 	r := chi.NewRouter()
 
 	r.Get("/", auth.HandleOAuthCallback(tokenStore, httpClient))
 	r.Get("/auth", auth.StartAuthFlow)
+	r.Get("/healthz", healthz)
+	r.Get("/readyz", readyz(tokenStore))
 
 	return r
 }
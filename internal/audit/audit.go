@@ -0,0 +1,192 @@
+// Package audit writes and reads the append-only, hash-chained token access log.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Caller identifies the process behind a socket command. Fields are zero when the
+// platform cannot report them.
+type Caller struct {
+	PID int    `json:"pid,omitempty"`
+	UID int    `json:"uid"`
+	Exe string `json:"exe,omitempty"`
+}
+
+// Entry is one line of the audit log. Hash is the SHA-256 of the entry encoded with
+// an empty Hash, and Prev is the Hash of the previous line, so editing or removing a
+// line breaks the chain.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Source  string    `json:"source"`
+	Account string    `json:"account,omitempty"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Caller  *Caller   `json:"caller,omitempty"`
+	Prev    string    `json:"prev"`
+	Hash    string    `json:"hash"`
+}
+
+// Log appends entries to an audit file.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	last string
+}
+
+// Open opens path for appending, creating it with owner-only permissions, and
+// continues the hash chain from its last entry.
+//
+// A last line that is not a complete entry, as left by a crash mid-write, is removed
+// with a warning. Other damage is left for Verify to report.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	last, err := repairTail(file, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Log{file: file, last: last}, nil
+}
+
+// repairTail returns the hash of the last entry in file, truncating an incomplete
+// last line first and terminating a complete one that lacks its newline.
+func repairTail(file *os.File, path string) (string, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	content := bytes.TrimRight(data, "\n")
+	if len(content) == 0 {
+		return "", nil
+	}
+	start := bytes.LastIndexByte(content, '\n') + 1
+	var e Entry
+	if err := json.Unmarshal(content[start:], &e); err != nil {
+		slog.Warn("audit log ends with an incomplete entry; removing it",
+			"path", path, "bytes", len(data)-start, "error", err)
+		if err := file.Truncate(int64(start)); err != nil {
+			return "", err
+		}
+		if start == 0 {
+			return "", nil
+		}
+		// The chain continues from the entry before; if that one is damaged too,
+		// audit verify reports the break.
+		content = content[:start-1]
+		start = bytes.LastIndexByte(content, '\n') + 1
+		e = Entry{}
+		if err := json.Unmarshal(content[start:], &e); err != nil {
+			return "", nil
+		}
+		return e.Hash, nil
+	}
+	if len(content) == len(data) {
+		if _, err := file.Write([]byte{'\n'}); err != nil {
+			return "", err
+		}
+	}
+	return e.Hash, nil
+}
+
+// Record chains e to the previous entry and appends it.
+func (l *Log) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Prev = l.last
+	hash, err := e.computeHash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.last = e.Hash
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Read returns every entry of the audit file at path.
+func Read(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Verify checks the hash chain of entries and returns an error naming the first
+// entry that was modified, removed or reordered.
+func Verify(entries []Entry) error {
+	prev := ""
+	for i, e := range entries {
+		if e.Prev != prev {
+			return fmt.Errorf("entry %d: chain broken (previous entry missing or reordered)", i+1)
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("entry %d: hash mismatch (entry modified)", i+1)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
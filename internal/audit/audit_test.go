@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeEntries(t *testing.T, path string, accounts ...string) {
+	t.Helper()
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, account := range accounts {
+		if err := l.Record(Entry{Event: "get-token", Source: "socket", Account: account, Result: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readVerified(t *testing.T, path string) []Entry {
+	t.Helper()
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(entries); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return entries
+}
+
+func TestRecordChainsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "audit.jsonl")
+	writeEntries(t, path, "a", "b")
+	writeEntries(t, path, "c")
+
+	entries := readVerified(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	if entries[0].Prev != "" || entries[2].Prev != entries[1].Hash {
+		t.Errorf("entries are not chained: %+v", entries)
+	}
+	if entries[0].Time.IsZero() {
+		t.Error("Record did not set the time")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEntries(t, path, "a", "b", "c")
+	entries := readVerified(t, path)
+
+	modified := append([]Entry(nil), entries...)
+	modified[1].Account = "x"
+	removed := []Entry{entries[0], entries[2]}
+	reordered := []Entry{entries[1], entries[0], entries[2]}
+
+	for name, tt := range map[string]struct {
+		entries []Entry
+		want    string
+	}{
+		"modified":  {modified, "entry 2: hash mismatch"},
+		"removed":   {removed, "entry 2: chain broken"},
+		"reordered": {reordered, "entry 1: chain broken"},
+	} {
+		err := Verify(tt.entries)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Verify = %v, want %q", name, err, tt.want)
+		}
+	}
+}
+
+func TestOpenRemovesIncompleteLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEntries(t, path, "a", "b")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, `{"time":"2026-01-01T00:00:00Z","event":"get-tok`...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	writeEntries(t, path, "c")
+
+	entries := readVerified(t, path)
+	if len(entries) != 3 || entries[2].Account != "c" {
+		t.Errorf("entries = %+v, want a, b and c", entries)
+	}
+}
+
+func TestOpenTerminatesCompleteLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEntries(t, path, "a")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.TrimSuffix(string(data), "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	writeEntries(t, path, "b")
+
+	if entries := readVerified(t, path); len(entries) != 2 {
+		t.Errorf("got %d entries, want 2", len(entries))
+	}
+}
+
+func TestOpenLeavesDamagedChainToVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEntries(t, path, "a", "b")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := os.WriteFile(path, []byte("garbage\n"+lines[1]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	writeEntries(t, path, "c")
+
+	if _, err := Read(path); err == nil || !strings.Contains(err.Error(), "audit.jsonl:1:") {
+		t.Errorf("Read = %v, want an error for line 1", err)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build linux
+
+package audit
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// PeerCaller returns the pid, uid and executable of the process on the other end of
+// a Unix socket connection, or nil when they cannot be determined.
+func PeerCaller(conn net.Conn) *Caller {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		return nil
+	}
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", cred.Pid))
+	return &Caller{PID: int(cred.Pid), UID: int(cred.Uid), Exe: exe}
+}
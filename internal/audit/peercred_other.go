@@ -0,0 +1,10 @@
+//go:build !linux
+
+package audit
+
+import "net"
+
+// PeerCaller is not supported on this platform and always returns nil.
+func PeerCaller(conn net.Conn) *Caller {
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"html"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
@@ -16,6 +16,21 @@ import (
 
 var LoadedAccounts map[string]*config.Account
 
+// LoadedHTTP holds the global [http] settings, which the callback combines with an
+// account's own for its code exchange.
+var LoadedHTTP config.HTTP
+
+// EventHook, when set, is called for authentication flow events: "auth_started" when
+// StartAuthFlow redirects to the provider, and "auth_completed" or "auth_failed" once
+// the callback for an account has been handled.
+var EventHook func(account, event string, err error)
+
+func emitEvent(account, event string, err error) {
+	if EventHook != nil {
+		EventHook(account, event, err)
+	}
+}
+
 const successHTML = `
 <!DOCTYPE html>
 <html lang="en">
@@ -537,7 +552,7 @@ const errorHTML = `
 
 func StartAuthFlow(w http.ResponseWriter, r *http.Request) {
 	accountName := r.URL.Query().Get("account")
-	acct, ok := LoadedAccounts[accountName]
+	acct, identity, ok := config.FindAccount(LoadedAccounts, accountName)
 	if !ok {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.WriteHeader(http.StatusBadRequest)
@@ -547,18 +562,38 @@ func StartAuthFlow(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, errorHTML, safeError)
 		return
 	}
+	if grant := acct.GrantType(); grant != config.GrantAuthorizationCode {
+		writeErrorPage(w, http.StatusBadRequest, html.EscapeString("Account '"+accountName+"' uses the "+grant+" grant and has no login."))
+		return
+	}
 	oauthCfg := config.GetOAuth2Config(acct)
 
 	state := "account:" + accountName
 	authURL := oauthCfg.AuthCodeURL(state, oauth2.AccessTypeOffline)
-
-	if hint, ok := acct.AuthURIFields["login_hint"]; ok {
-		authURL += "&login_hint=" + url.QueryEscape(hint)
+	if params := config.AuthParams(acct, identity); len(params) > 0 {
+		authURL = withQueryParams(authURL, params)
 	}
 
+	emitEvent(accountName, "auth_started", nil)
+
 	http.Redirect(w, r, authURL, http.StatusFound)
 }
 
+// withQueryParams sets params in the query of rawURL, replacing values of the same
+// name.
+func withQueryParams(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
 // writeErrorPage writes an HTML error response using the provided HTTP status and message.
 // It sets the Content-Type to "text/html; charset=utf-8" and writes the body by formatting the package's errorHTML template with the message.
 func writeErrorPage(w http.ResponseWriter, status int, message string) {
@@ -581,16 +616,20 @@ func HandleOAuthCallback(tokenStore storage.TokenStore, httpClient *http.Client)
 		}
 		accountName := strings.TrimPrefix(state, "account:")
 
-		acct, ok := LoadedAccounts[accountName]
-		if !ok {
+		acct, _, ok := config.FindAccount(LoadedAccounts, accountName)
+		if !ok || acct.GrantType() != config.GrantAuthorizationCode {
 			writeErrorPage(w, http.StatusBadRequest, "Invalid Account")
 			return
 		}
 		oauthCfg := config.GetOAuth2Config(acct)
-		ctx := context.Background()
-		if httpClient != nil {
-			ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+		client, err := TokenClient(acct, config.AccountHTTP(LoadedHTTP, acct), httpClient, config.TokenParams(acct))
+		if err != nil {
+			writeErrorPage(w, http.StatusInternalServerError, "Client authentication is misconfigured.")
+			slog.Error("token client setup failed", "account", accountName, "error", err)
+			emitEvent(accountName, "auth_failed", err)
+			return
 		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
 
 		code := r.URL.Query().Get("code")
 		token, err := oauthCfg.Exchange(ctx, code)
@@ -598,15 +637,18 @@ func HandleOAuthCallback(tokenStore storage.TokenStore, httpClient *http.Client)
 
 			writeErrorPage(w, http.StatusInternalServerError, "failed to exchange token. Please try again.")
 
-			log.Printf("token exchange error for account %s: %v", accountName, err)
+			slog.Error("token exchange failed", "account", accountName, "error", err)
+			emitEvent(accountName, "auth_failed", err)
 			return
 		}
 
 		if err := tokenStore.Set(accountName, token); err != nil {
-			log.Printf("failed to save token for account %s: %v", accountName, err)
+			slog.Error("failed to save token", "account", accountName, "error", err)
 			writeErrorPage(w, http.StatusInternalServerError, "Authentication succeeded but failed to save token.")
+			emitEvent(accountName, "auth_failed", err)
 			return
 		}
+		emitEvent(accountName, "auth_completed", nil)
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestWithQueryParams(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		params url.Values
+		want   string
+	}{
+		{
+			"https://login.example.com/authorize?client_id=app&state=account%3Awork",
+			url.Values{"prompt": {"consent"}},
+			"https://login.example.com/authorize?client_id=app&prompt=consent&state=account%3Awork",
+		},
+		{
+			"https://login.example.com/authorize?access_type=offline&client_id=app",
+			url.Values{"access_type": {"online"}, "resource": {"urn:a", "urn:b"}},
+			"https://login.example.com/authorize?access_type=online&client_id=app&resource=urn%3Aa&resource=urn%3Ab",
+		},
+		{
+			"https://login.example.com/authorize",
+			url.Values{"login_hint": {"alice@example.com"}},
+			"https://login.example.com/authorize?login_hint=alice%40example.com",
+		},
+		{
+			"https://login.example.com/authorize?tenant=a#fragment",
+			nil,
+			"https://login.example.com/authorize?tenant=a#fragment",
+		},
+		{"://bad", url.Values{"prompt": {"consent"}}, "://bad"},
+	}
+	for _, tt := range tests {
+		if got := withQueryParams(tt.rawURL, tt.params); got != tt.want {
+			t.Errorf("withQueryParams(%q, %v) = %q, want %q", tt.rawURL, tt.params, got, tt.want)
+		}
+	}
+}
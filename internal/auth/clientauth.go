@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/jwt"
+)
+
+// clientAssertionType is the client_assertion_type of private_key_jwt (RFC 7523).
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// accountClients caches the http.Client of each account, so token requests of an
+// account reuse its connections and its CA file is read once. The client
+// certificate is checked for changes on every handshake (see clientCertificate).
+var accountClients sync.Map // clientKey -> *http.Client
+
+type clientKey struct {
+	acct     *config.Account
+	base     *http.Client
+	settings config.HTTP
+}
+
+// TokenClient returns the http.Client for token endpoint requests of acct: base
+// configured with settings, sending params with every request and authenticating
+// the client as set by token_endpoint_auth_method. private_key_jwt adds a freshly
+// signed client assertion to each request; tls_client_auth presents the configured
+// client certificate.
+func TokenClient(acct *config.Account, settings config.HTTP, base *http.Client, params url.Values) (*http.Client, error) {
+	client, err := accountClient(acct, settings, base)
+	if err != nil {
+		return nil, err
+	}
+
+	var dynamic func() (url.Values, error)
+	if acct.TokenEndpointAuthMethod == config.AuthPrivateKeyJWT {
+		key, err := jwt.LoadKey(acct.ClientAssertionKey)
+		if err != nil {
+			return nil, fmt.Errorf("client assertion key: %w", err)
+		}
+		audience := acct.ClientAssertionAudience
+		if audience == "" {
+			audience = acct.TokenURI
+		}
+		dynamic = func() (url.Values, error) {
+			assertion, err := jwt.Sign(key, acct.ClientAssertionKeyID, jwt.Claims{
+				Issuer:   acct.ClientID,
+				Subject:  acct.ClientID,
+				Audience: []string{audience},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("sign client assertion: %w", err)
+			}
+			return url.Values{
+				"client_assertion_type": {clientAssertionType},
+				"client_assertion":      {assertion},
+			}, nil
+		}
+	}
+
+	if len(params) == 0 && dynamic == nil {
+		return client, nil
+	}
+	withParams := *client
+	withParams.Transport = &tokenParams{base: client.Transport, params: params, dynamic: dynamic}
+	return &withParams, nil
+}
+
+// accountClient returns the cached client of acct for settings and base, building it
+// on first use.
+func accountClient(acct *config.Account, settings config.HTTP, base *http.Client) (*http.Client, error) {
+	key := clientKey{acct: acct, base: base, settings: settings}
+	if client, ok := accountClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	var clientCert clientCertFunc
+	if acct.TokenEndpointAuthMethod == config.AuthTLSClient {
+		cert := &clientCertificate{certFile: acct.TLSClientCert, keyFile: acct.TLSClientKey}
+		if _, err := cert.get(nil); err != nil {
+			return nil, fmt.Errorf("tls client certificate: %w", err)
+		}
+		clientCert = cert.get
+	}
+	client, err := newHTTPClient(base, settings, clientCert)
+	if err != nil {
+		return nil, err
+	}
+	accountClients.Store(key, client)
+	return client, nil
+}
+
+// clientCertificate serves the tls_client_cert and tls_client_key of an account. The
+// pair is loaded again when either file changes, so a renewed certificate is used
+// for the next connection without a restart.
+type clientCertificate struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *clientCertificate) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	modTime, statErr := latestModTime(c.certFile, c.keyFile)
+	if c.cert != nil && statErr == nil && modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// The files may be in the middle of being replaced.
+			slog.Warn("reloading tls client certificate failed; using the previous one", "cert", c.certFile, "error", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	c.cert, c.modTime = &cert, modTime
+	return c.cert, nil
+}
+
+// latestModTime returns the most recent modification time of paths.
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for name and its key to dir,
+// dated modTime.
+func writeCertificate(t *testing.T, dir, name string, modTime time.Time) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, c *clientCertificate) string {
+	t.Helper()
+	cert, err := c.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestClientCertificateReloadsRenewedFiles(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	certFile, keyFile := writeCertificate(t, dir, "first", start)
+	c := &clientCertificate{certFile: certFile, keyFile: keyFile}
+
+	if got := commonName(t, c); got != "first" {
+		t.Fatalf("certificate = %q, want first", got)
+	}
+	if got := commonName(t, c); got != "first" {
+		t.Errorf("unchanged files: certificate = %q, want first", got)
+	}
+
+	writeCertificate(t, dir, "renewed", start.Add(time.Minute))
+	if got := commonName(t, c); got != "renewed" {
+		t.Errorf("after renewal: certificate = %q, want renewed", got)
+	}
+
+	// A half-written renewal keeps the previous certificate in use.
+	os.WriteFile(keyFile, []byte("partial"), 0o600)
+	if got := commonName(t, c); got != "renewed" {
+		t.Errorf("after a broken renewal: certificate = %q, want renewed", got)
+	}
+}
+
+func TestClientCertificateMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	c := &clientCertificate{certFile: filepath.Join(dir, "missing.crt"), keyFile: filepath.Join(dir, "missing.key")}
+	if _, err := c.get(nil); err == nil {
+		t.Error("missing certificate loaded")
+	}
+}
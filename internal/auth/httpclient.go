@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+// ProxyNone as the proxy setting connects directly, ignoring the proxy environment
+// variables and a global proxy.
+const ProxyNone = "none"
+
+// HTTPClient returns a copy of base, or of a new client when base is nil, configured
+// with settings: its proxy, extra CA certificates, minimum TLS version, timeout and
+// User-Agent.
+func HTTPClient(base *http.Client, settings config.HTTP) (*http.Client, error) {
+	return newHTTPClient(base, settings, nil)
+}
+
+// clientCertFunc supplies the client certificate of tls_client_auth.
+type clientCertFunc func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+func newHTTPClient(base *http.Client, settings config.HTTP, clientCert clientCertFunc) (*http.Client, error) {
+	client := &http.Client{}
+	if base != nil {
+		*client = *base
+	}
+	if settings.Timeout > 0 {
+		client.Timeout = settings.Timeout
+	}
+
+	if settings.Proxy != "" || settings.CAFile != "" || settings.TLSMinVersion != "" || clientCert != nil {
+		transport := cloneTransport(client.Transport)
+		if transport == nil {
+			return nil, fmt.Errorf("http settings need an *http.Transport, not %T", client.Transport)
+		}
+		if err := configureTransport(transport, settings); err != nil {
+			return nil, err
+		}
+		if clientCert != nil {
+			transport.TLSClientConfig.GetClientCertificate = clientCert
+		}
+		client.Transport = transport
+	}
+
+	if settings.UserAgent != "" {
+		client.Transport = &userAgent{base: client.Transport, agent: settings.UserAgent}
+	}
+	return client, nil
+}
+
+func configureTransport(transport *http.Transport, settings config.HTTP) error {
+	switch settings.Proxy {
+	case "":
+	case ProxyNone:
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(settings.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("invalid proxy %q", settings.Proxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("proxy %q must be an http, https or socks5 URL", settings.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if settings.CAFile != "" {
+		data, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return fmt.Errorf("ca_file: %w", err)
+		}
+		roots := transport.TLSClientConfig.RootCAs
+		if roots != nil {
+			roots = roots.Clone()
+		} else if roots, err = x509.SystemCertPool(); err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("ca_file %s: no PEM certificates", settings.CAFile)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+
+	if settings.TLSMinVersion != "" {
+		version, err := tlsVersion(settings.TLSMinVersion)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig.MinVersion = version
+	}
+	return nil
+}
+
+func tlsVersion(name string) (uint16, error) {
+	switch name {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("invalid tls_min_version %q; use 1.0, 1.1, 1.2 or 1.3", name)
+}
+
+// cloneTransport returns a copy of rt, or of http.DefaultTransport when rt is nil,
+// with its own TLS config. It returns nil for other round trippers.
+func cloneTransport(rt http.RoundTripper) *http.Transport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	clone := transport.Clone()
+	if clone.TLSClientConfig == nil {
+		clone.TLSClientConfig = &tls.Config{}
+	}
+	return clone
+}
+
+// userAgent is an http.RoundTripper that sets the User-Agent header of requests.
+type userAgent struct {
+	base  http.RoundTripper
+	agent string
+}
+
+func (u *userAgent) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := u.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	out := req.Clone(req.Context())
+	out.Header.Set("User-Agent", u.agent)
+	return base.RoundTrip(out)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// tokenParams is an http.RoundTripper that adds params to the form body of token
+// endpoint requests. x/oauth2 takes no extra parameters for refresh requests, so
+// settings such as the scope of a scope set reach the token endpoint this way.
+// dynamic, when set, adds parameters that must be fresh for every request, such as
+// a client assertion.
+type tokenParams struct {
+	base    http.RoundTripper
+	params  url.Values
+	dynamic func() (url.Values, error)
+}
+
+func (t *tokenParams) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Method != http.MethodPost || req.Body == nil ||
+		!strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range t.params {
+		form[key] = values
+	}
+	if t.dynamic != nil {
+		extra, err := t.dynamic()
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range extra {
+			form[key] = values
+		}
+	}
+	encoded := form.Encode()
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(strings.NewReader(encoded))
+	out.ContentLength = int64(len(encoded))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(encoded)), nil
+	}
+	return base.RoundTrip(out)
+}
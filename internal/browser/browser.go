@@ -0,0 +1,40 @@
+// Package browser opens URLs in the user's web browser.
+package browser
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Open opens url with command when it is set, else with $BROWSER, else with the
+// platform's URL handler (xdg-open, open or the Windows shell). command is run by the
+// shell with the URL as its last argument. Open does not wait for the browser; the
+// process is reaped in the background when it exits.
+func Open(command, url string) error {
+	args := commandFor(command, url)
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+func commandFor(command, url string) []string {
+	if command = strings.TrimSpace(command); command == "" {
+		command = strings.TrimSpace(os.Getenv("BROWSER"))
+	}
+	if command != "" {
+		return []string{"sh", "-c", command + ` "$1"`, "sh", url}
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"open", url}
+	case "windows":
+		return []string{"rundll32", "url.dll,FileProtocolHandler", url}
+	default:
+		return []string{"xdg-open", url}
+	}
+}
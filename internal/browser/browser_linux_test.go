@@ -0,0 +1,31 @@
+package browser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenReapsTheBrowserProcess(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	if err := Open(`echo $$ > "`+pidFile+`"; :`, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	var pid string
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		data, err := os.ReadFile(pidFile)
+		if pid = strings.TrimSpace(string(data)); err == nil && pid != "" {
+			_, err := os.Stat(filepath.Join("/proc", pid))
+			if errors.Is(err, os.ErrNotExist) {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("browser process %q was not reaped", pid)
+		}
+	}
+}
@@ -286,3 +286,35 @@ func EnsureLocalCA() (string, error) {
 
 	return caCertPath, nil
 }
+
+// LocalCerts returns the local CA and localhost leaf certificates without generating
+// missing ones. Either may be nil when its file does not exist yet.
+func LocalCerts() (ca *x509.Certificate, leaf *x509.Certificate, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certDir := filepath.Join(home, ".vybr", "vygrant", "certs")
+	for _, item := range []struct {
+		path string
+		dest **x509.Certificate
+	}{
+		{filepath.Join(certDir, "vygrant_ca.pem"), &ca},
+		{filepath.Join(certDir, "localhost.pem"), &leaf},
+	} {
+		certPEM, err := os.ReadFile(item.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := loadFirstCert(certPEM)
+		if err != nil {
+			return nil, nil, err
+		}
+		*item.dest = cert
+	}
+	return ca, leaf, nil
+}
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -64,3 +66,35 @@ func SendCommandWithPayload(command string, payload []byte) (string, error) {
 
 	return readResponse(conn)
 }
+
+// Subscribe sends command, which must be a streaming command such as subscribe, and
+// calls fn with every line the daemon writes until the daemon closes the connection
+// or fn returns an error.
+func Subscribe(command string, fn func(line string) error) error {
+	conn, err := net.Dial("unix", daemon.SocketPath())
+	if err != nil {
+		return fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+	defer conn.Close()
+
+	// The write side stays open: the daemon ends the stream when it sees EOF.
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if msg, ok := strings.CutPrefix(line, "ERROR: "); ok {
+			return errors.New(msg)
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read events: %w", err)
+	}
+	return nil
+}
@@ -2,20 +2,284 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"golang.org/x/oauth2"
 )
 
 type Account struct {
-	AuthURI       string            `toml:"auth_uri"`
-	TokenURI      string            `toml:"token_uri"`
-	ClientID      string            `toml:"client_id"`
-	ClientSecret  string            `toml:"client_secret"`
-	RedirectURI   string            `toml:"redirect_uri"`
-	Scopes        []string          `toml:"scopes"`
-	AuthURIFields map[string]string `toml:"auth_uri_fields"`
+	AuthURI                 string               `toml:"auth_uri"`
+	TokenURI                string               `toml:"token_uri"`
+	ClientID                string               `toml:"client_id"`
+	ClientSecret            string               `toml:"client_secret"`
+	RedirectURI             string               `toml:"redirect_uri"`
+	Scopes                  Scopes               `toml:"scopes"`
+	AuthURIFields           map[string]string    `toml:"auth_uri_fields"`
+	TokenURIFields          map[string]string    `toml:"token_uri_fields"`
+	Resource                []string             `toml:"resource"`
+	Audience                []string             `toml:"audience"`
+	TokenEndpointAuthMethod string               `toml:"token_endpoint_auth_method"`
+	ClientAssertionKey      string               `toml:"client_assertion_key"`
+	ClientAssertionKeyID    string               `toml:"client_assertion_key_id"`
+	ClientAssertionAudience string               `toml:"client_assertion_audience"`
+	TLSClientCert           string               `toml:"tls_client_cert"`
+	TLSClientKey            string               `toml:"tls_client_key"`
+	RefreshBefore           time.Duration        `toml:"refresh_before"`
+	CheckInterval           time.Duration        `toml:"check_interval"`
+	Identities              map[string]*Identity `toml:"identity"`
+	HTTP                    HTTP                 `toml:"http"`
+	Grant                   string               `toml:"grant"`
+	SubjectAccount          string               `toml:"subject_account"`
+	RequestedTokenType      string               `toml:"requested_token_type"`
+	KeyFile                 string               `toml:"key_file"`
+	KeyID                   string               `toml:"key_id"`
+	Issuer                  string               `toml:"issuer"`
+	Subject                 string               `toml:"subject"`
+	AssertionAudience       []string             `toml:"assertion_audience"`
+}
+
+// Identity is a second login with the client registration of its account, such as
+// another mailbox. Its tokens are stored under the key "account/identity".
+type Identity struct {
+	LoginHint string `toml:"login_hint"`
+}
+
+// Token endpoint authentication methods (token_endpoint_auth_method). Without one,
+// the client secret is sent in a header or in the form, whichever the provider
+// accepts.
+const (
+	AuthClientSecretBasic = "client_secret_basic"
+	AuthClientSecretPost  = "client_secret_post"
+	AuthPrivateKeyJWT     = "private_key_jwt"
+	AuthTLSClient         = "tls_client_auth"
+)
+
+// IdentitySeparator separates the account and the identity in a token key.
+const IdentitySeparator = "/"
+
+// ScopeSeparator separates a token key from the name of a scope set.
+const ScopeSeparator = "#"
+
+// ScopeSet is a named set of scopes of an account, such as the scopes of one
+// resource. Its access token is obtained with the account's refresh token and
+// cached under the key "account#set".
+type ScopeSet struct {
+	Scopes []string `toml:"scopes"`
+}
+
+// Scopes is the scopes setting of an account: either a list of scopes, or a table
+// of named scope sets ([account.x.scopes.imap]) that share one refresh token.
+type Scopes struct {
+	List []string
+	Sets map[string]ScopeSet
+}
+
+// UnmarshalTOML decodes a list of scopes or a table of scope sets. A scope set is a
+// table with a scopes list, or the list itself.
+func (s *Scopes) UnmarshalTOML(data any) error {
+	switch value := data.(type) {
+	case []any:
+		list, err := stringList(value)
+		if err != nil {
+			return fmt.Errorf("scopes: %w", err)
+		}
+		s.List = list
+	case map[string]any:
+		s.Sets = make(map[string]ScopeSet, len(value))
+		for name, raw := range value {
+			if table, ok := raw.(map[string]any); ok {
+				for key := range table {
+					if key != "scopes" {
+						return fmt.Errorf("scope set %q: unknown key %q", name, key)
+					}
+				}
+				raw = table["scopes"]
+			}
+			items, ok := raw.([]any)
+			if !ok {
+				return fmt.Errorf("scope set %q must be a list of scopes or a table with scopes", name)
+			}
+			list, err := stringList(items)
+			if err != nil {
+				return fmt.Errorf("scope set %q: %w", name, err)
+			}
+			s.Sets[name] = ScopeSet{Scopes: list}
+		}
+	default:
+		return fmt.Errorf("scopes must be a list or a table of scope sets")
+	}
+	return nil
+}
+
+func stringList(items []any) ([]string, error) {
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a string", item)
+		}
+		list = append(list, str)
+	}
+	return list, nil
+}
+
+// All returns the scopes requested when the account is authorized: the list, or
+// the scopes of every scope set so that the refresh token covers all of them.
+func (s Scopes) All() []string {
+	if len(s.Sets) == 0 {
+		return s.List
+	}
+	names := make([]string, 0, len(s.Sets))
+	for name := range s.Sets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var all []string
+	for _, name := range names {
+		for _, scope := range s.Sets[name].Scopes {
+			if !slices.Contains(all, scope) {
+				all = append(all, scope)
+			}
+		}
+	}
+	return all
+}
+
+// For returns the scopes of the named scope set, or All when set is empty.
+func (s Scopes) For(set string) []string {
+	if set == "" {
+		return s.All()
+	}
+	return s.Sets[set].Scopes
+}
+
+const (
+	// DefaultRefreshBefore is how long before expiry a token is refreshed.
+	DefaultRefreshBefore = 10 * time.Minute
+	// DefaultCheckInterval is the longest the scheduler waits before looking at an
+	// account again.
+	DefaultCheckInterval = 30 * time.Minute
+	// DefaultLoginTimeout is how long a login waits for the OAuth2 callback.
+	DefaultLoginTimeout = 5 * time.Minute
+	// DefaultHTTPTimeout bounds a request to an OAuth2 endpoint when no timeout is
+	// configured.
+	DefaultHTTPTimeout = 30 * time.Second
+)
+
+// HTTP configures the HTTP client used for an account's token endpoint: globally in
+// [http] and per account in [account.x.http], where set fields take precedence.
+type HTTP struct {
+	Proxy         string        `toml:"proxy"`
+	CAFile        string        `toml:"ca_file"`
+	TLSMinVersion string        `toml:"tls_min_version"`
+	Timeout       time.Duration `toml:"timeout"`
+	UserAgent     string        `toml:"user_agent"`
+}
+
+// AccountHTTP returns the HTTP settings of acct: its own fields, else the global
+// ones, with DefaultHTTPTimeout when neither sets a timeout.
+func AccountHTTP(global HTTP, acct *Account) HTTP {
+	settings := global
+	if acct != nil {
+		own := acct.HTTP
+		if own.Proxy != "" {
+			settings.Proxy = own.Proxy
+		}
+		if own.CAFile != "" {
+			settings.CAFile = own.CAFile
+		}
+		if own.TLSMinVersion != "" {
+			settings.TLSMinVersion = own.TLSMinVersion
+		}
+		if own.Timeout > 0 {
+			settings.Timeout = own.Timeout
+		}
+		if own.UserAgent != "" {
+			settings.UserAgent = own.UserAgent
+		}
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = DefaultHTTPTimeout
+	}
+	return settings
+}
+
+// ProxyRule maps requests whose host and path match to the account whose
+// access token is added as a Bearer Authorization header.
+type ProxyRule struct {
+	Host       string `toml:"host"`
+	PathPrefix string `toml:"path_prefix"`
+	Account    string `toml:"account"`
+}
+
+// ReverseProxy forwards everything received on Listen to Upstream using the
+// access token of Account.
+type ReverseProxy struct {
+	Listen   string `toml:"listen"`
+	Upstream string `toml:"upstream"`
+	Account  string `toml:"account"`
+}
+
+type Proxy struct {
+	Listen  string         `toml:"listen"`
+	Rules   []ProxyRule    `toml:"rule"`
+	Reverse []ReverseProxy `toml:"reverse"`
+}
+
+// Template renders Source with text/template into Destination whenever a token it
+// references changes, then runs Command if set. Mode is an octal permission string.
+type Template struct {
+	Source      string `toml:"source"`
+	Destination string `toml:"destination"`
+	Mode        string `toml:"mode"`
+	Command     string `toml:"command"`
+}
+
+// Hook runs Command for the listed Events and Accounts, or for all of them when a
+// list is empty. Event details are written to the command's stdin as JSON; the
+// access token is only included when IncludeToken is set. A failing command is
+// retried Retries times.
+type Hook struct {
+	Events       []string      `toml:"events"`
+	Accounts     []string      `toml:"accounts"`
+	Command      string        `toml:"command"`
+	IncludeToken bool          `toml:"include_token"`
+	Timeout      time.Duration `toml:"timeout"`
+	Retries      int           `toml:"retries"`
+}
+
+// Notify selects how the daemon notifies the user. Backend is desktop (default),
+// dbus, webhook, command or none. Severity overrides the severity of an event, or
+// disables it with "off"; notifications below MinSeverity are dropped. RateLimit is
+// the minimum time between two notifications for the same event and account, and
+// identical notifications are suppressed for DedupWindow.
+type Notify struct {
+	Backend        string            `toml:"backend"`
+	MinSeverity    string            `toml:"min_severity"`
+	Severity       map[string]string `toml:"severity"`
+	RateLimit      time.Duration     `toml:"rate_limit"`
+	DedupWindow    time.Duration     `toml:"dedup_window"`
+	WebhookURL     string            `toml:"webhook_url"`
+	WebhookTopic   string            `toml:"webhook_topic"`
+	WebhookHeaders map[string]string `toml:"webhook_headers"`
+	Command        string            `toml:"command"`
+}
+
+// Log configures the daemon logger. Level is debug, info, warn or error; Format is
+// text or json. When File is set logs are written there and rotated after MaxSizeMB,
+// keeping MaxBackups old files.
+type Log struct {
+	Level      string `toml:"level"`
+	Format     string `toml:"format"`
+	File       string `toml:"file"`
+	MaxSizeMB  int    `toml:"max_size_mb"`
+	MaxBackups int    `toml:"max_backups"`
 }
 
 type Config struct {
@@ -23,6 +287,19 @@ type Config struct {
 	HTTPListen    string              `toml:"http_listen"`
 	PersistTokens bool                `toml:"persist_tokens"`
 	TokenEventCmd string              `toml:"token_event_cmd"`
+	MetricsListen string              `toml:"metrics_listen"`
+	AuditLog      string              `toml:"audit_log"`
+	RefreshBefore time.Duration       `toml:"refresh_before"`
+	CheckInterval time.Duration       `toml:"check_interval"`
+	Browser       string              `toml:"browser"`
+	AutoLogin     bool                `toml:"auto_login"`
+	LoginTimeout  time.Duration       `toml:"login_timeout"`
+	Log           Log                 `toml:"log"`
+	Notify        Notify              `toml:"notify"`
+	Proxy         Proxy               `toml:"proxy"`
+	HTTP          HTTP                `toml:"http"`
+	Templates     []Template          `toml:"template"`
+	Hooks         []Hook              `toml:"hook"`
 	Accounts      map[string]*Account `toml:"account"`
 }
 
@@ -37,15 +314,201 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// OAuth2 grants of an account (grant). Accounts without one log in with the
+// authorization code grant.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	// GrantTokenExchange derives the account's tokens from the access token of
+	// subject_account (RFC 8693).
+	GrantTokenExchange = "token_exchange"
+	// GrantJWTBearer obtains the account's tokens with assertions signed by
+	// key_file, as service accounts do (RFC 7523).
+	GrantJWTBearer = "jwt_bearer"
+)
+
+// GrantType returns the OAuth2 grant used to obtain the account's tokens.
+func (a *Account) GrantType() string {
+	if a.Grant == "" {
+		return GrantAuthorizationCode
+	}
+	return a.Grant
+}
+
+// TokenKey returns the token store key of identity of account, or account itself
+// when identity is empty.
+func TokenKey(account, identity string) string {
+	if identity == "" {
+		return account
+	}
+	return account + IdentitySeparator + identity
+}
+
+// SplitTokenKey splits a token key into its account and identity. A scope set
+// suffix is dropped.
+func SplitTokenKey(key string) (account, identity string) {
+	key, _ = SplitScopeKey(key)
+	account, identity, _ = strings.Cut(key, IdentitySeparator)
+	return account, identity
+}
+
+// SplitScopeKey splits the token key "key#set" into the key that holds the refresh
+// token and the name of the scope set, which is empty for other keys.
+func SplitScopeKey(key string) (base, set string) {
+	base, set, _ = strings.Cut(key, ScopeSeparator)
+	return base, set
+}
+
+// KeyBelongsTo reports whether the token key is account, one of its scope sets or,
+// when account names no identity, one of its identities.
+func KeyBelongsTo(key, account string) bool {
+	if key == account {
+		return true
+	}
+	if strings.Contains(account, ScopeSeparator) {
+		return false
+	}
+	base, _ := SplitScopeKey(key)
+	return base == account || strings.HasPrefix(base, account+IdentitySeparator) && !strings.Contains(account, IdentitySeparator)
+}
+
+// FindAccount resolves the token key "account", "account/identity" or either of
+// them with a "#set" suffix in accounts. identity is nil for the account's own key;
+// ok is false when the account, the identity or the scope set is not configured.
+func FindAccount(accounts map[string]*Account, key string) (acct *Account, identity *Identity, ok bool) {
+	_, set := SplitScopeKey(key)
+	name, identityName := SplitTokenKey(key)
+	acct, ok = accounts[name]
+	if !ok || acct == nil {
+		return nil, nil, false
+	}
+	if set != "" {
+		if _, ok := acct.Scopes.Sets[set]; !ok {
+			return nil, nil, false
+		}
+	}
+	if identityName == "" {
+		return acct, nil, true
+	}
+	identity, ok = acct.Identities[identityName]
+	return acct, identity, ok
+}
+
+// LookupAccount returns the account of the token key "account" or
+// "account/identity".
+func (c *Config) LookupAccount(key string) (*Account, bool) {
+	acct, _, ok := FindAccount(c.Accounts, key)
+	return acct, ok
+}
+
+// TokenKeys returns the token key of every account, sorted by name, each followed by
+// the sorted keys of its identities.
+func (c *Config) TokenKeys() []string {
+	keys := make([]string, 0, len(c.Accounts))
+	for _, name := range slices.Sorted(maps.Keys(c.Accounts)) {
+		keys = append(keys, name)
+		if acct := c.Accounts[name]; acct != nil {
+			for _, identity := range slices.Sorted(maps.Keys(acct.Identities)) {
+				keys = append(keys, TokenKey(name, identity))
+			}
+		}
+	}
+	return keys
+}
+
+// AuthParams returns the parameters added to the authorize URL of identity of acct:
+// every auth_uri_fields entry, the resource and audience lists (RFC 8707), and the
+// identity's login_hint.
+func AuthParams(acct *Account, identity *Identity) url.Values {
+	params := url.Values{}
+	for key, value := range acct.AuthURIFields {
+		params.Set(key, value)
+	}
+	addResourceParams(params, acct)
+	if identity != nil && identity.LoginHint != "" {
+		params.Set("login_hint", identity.LoginHint)
+	}
+	return params
+}
+
+// TokenParams returns the parameters added to code exchange and refresh requests
+// of acct: every token_uri_fields entry and the resource and audience lists.
+func TokenParams(acct *Account) url.Values {
+	params := url.Values{}
+	for key, value := range acct.TokenURIFields {
+		params.Set(key, value)
+	}
+	addResourceParams(params, acct)
+	return params
+}
+
+func addResourceParams(params url.Values, acct *Account) {
+	for _, resource := range acct.Resource {
+		params.Add("resource", resource)
+	}
+	for _, audience := range acct.Audience {
+		params.Add("audience", audience)
+	}
+}
+
+// AccountRefreshBefore returns refresh_before for account: the account setting, else
+// the global one, else DefaultRefreshBefore.
+func (c *Config) AccountRefreshBefore(account string) time.Duration {
+	if acct, _ := c.LookupAccount(account); acct != nil && acct.RefreshBefore > 0 {
+		return acct.RefreshBefore
+	}
+	if c.RefreshBefore > 0 {
+		return c.RefreshBefore
+	}
+	return DefaultRefreshBefore
+}
+
+// AccountCheckInterval returns check_interval for account: the account setting, else
+// the global one, else DefaultCheckInterval.
+func (c *Config) AccountCheckInterval(account string) time.Duration {
+	if acct, _ := c.LookupAccount(account); acct != nil && acct.CheckInterval > 0 {
+		return acct.CheckInterval
+	}
+	if c.CheckInterval > 0 {
+		return c.CheckInterval
+	}
+	return DefaultCheckInterval
+}
+
+// LoginWait returns login_timeout, else DefaultLoginTimeout.
+func (c *Config) LoginWait() time.Duration {
+	if c.LoginTimeout > 0 {
+		return c.LoginTimeout
+	}
+	return DefaultLoginTimeout
+}
+
 func GetOAuth2Config(acct *Account) *oauth2.Config {
+	secret := acct.ClientSecret
+	if acct.TokenEndpointAuthMethod == AuthPrivateKeyJWT || acct.TokenEndpointAuthMethod == AuthTLSClient {
+		// These methods authenticate the client without its secret.
+		secret = ""
+	}
 	return &oauth2.Config{
 		ClientID:     acct.ClientID,
-		ClientSecret: acct.ClientSecret,
+		ClientSecret: secret,
 		RedirectURL:  acct.RedirectURI,
-		Scopes:       acct.Scopes,
+		Scopes:       acct.Scopes.All(),
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  acct.AuthURI,
-			TokenURL: acct.TokenURI,
+			AuthURL:   acct.AuthURI,
+			TokenURL:  acct.TokenURI,
+			AuthStyle: authStyle(acct.TokenEndpointAuthMethod),
 		},
 	}
 }
+
+// authStyle maps a token endpoint authentication method to how x/oauth2 sends the
+// client credentials. Methods without a secret send the client_id in the form.
+func authStyle(method string) oauth2.AuthStyle {
+	switch method {
+	case AuthClientSecretBasic:
+		return oauth2.AuthStyleInHeader
+	case AuthClientSecretPost, AuthPrivateKeyJWT, AuthTLSClient:
+		return oauth2.AuthStyleInParams
+	}
+	return oauth2.AuthStyleAutoDetect
+}
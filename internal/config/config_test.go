@@ -0,0 +1,157 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenKey(t *testing.T) {
+	tests := []struct {
+		account, identity, want string
+	}{
+		{"work", "", "work"},
+		{"work", "alice", "work/alice"},
+		{"work#mail", "", "work#mail"},
+	}
+	for _, tt := range tests {
+		if got := TokenKey(tt.account, tt.identity); got != tt.want {
+			t.Errorf("TokenKey(%q, %q) = %q, want %q", tt.account, tt.identity, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTokenKey(t *testing.T) {
+	tests := []struct {
+		key, account, identity string
+	}{
+		{"work", "work", ""},
+		{"work/alice", "work", "alice"},
+		{"work#mail", "work", ""},
+		{"work/alice#mail", "work", "alice"},
+		{"work/alice/extra", "work", "alice/extra"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		account, identity := SplitTokenKey(tt.key)
+		if account != tt.account || identity != tt.identity {
+			t.Errorf("SplitTokenKey(%q) = %q, %q; want %q, %q", tt.key, account, identity, tt.account, tt.identity)
+		}
+	}
+}
+
+func TestKeyBelongsTo(t *testing.T) {
+	tests := []struct {
+		key, account string
+		want         bool
+	}{
+		{"work", "work", true},
+		{"work/alice", "work", true},
+		{"work#mail", "work", true},
+		{"work/alice#mail", "work", true},
+		{"works", "work", false},
+		{"work-x", "work", false},
+		{"work/alice", "work/alice", true},
+		{"work/alice#mail", "work/alice", true},
+		{"work/bob", "work/alice", false},
+		{"work/alice/x", "work/alice", false},
+		{"work#mail", "work#mail", true},
+		{"work#mail2", "work#mail", false},
+		{"work/alice#mail", "work#mail", false},
+		{"work", "work/alice", false},
+	}
+	for _, tt := range tests {
+		if got := KeyBelongsTo(tt.key, tt.account); got != tt.want {
+			t.Errorf("KeyBelongsTo(%q, %q) = %v, want %v", tt.key, tt.account, got, tt.want)
+		}
+	}
+}
+
+func TestFindAccount(t *testing.T) {
+	alice := &Identity{LoginHint: "alice@example.com"}
+	work := &Account{
+		Identities: map[string]*Identity{"alice": alice},
+		Scopes:     Scopes{Sets: map[string]ScopeSet{"mail": {Scopes: []string{"mail"}}}},
+	}
+	accounts := map[string]*Account{"work": work, "broken": nil}
+
+	tests := []struct {
+		key          string
+		wantAccount  *Account
+		wantIdentity *Identity
+		wantOK       bool
+	}{
+		{"work", work, nil, true},
+		{"work/alice", work, alice, true},
+		{"work#mail", work, nil, true},
+		{"work/alice#mail", work, alice, true},
+		{"work/bob", work, nil, false},
+		{"work#calendar", nil, nil, false},
+		{"work/alice#calendar", nil, nil, false},
+		{"home", nil, nil, false},
+		{"broken", nil, nil, false},
+		{"", nil, nil, false},
+	}
+	for _, tt := range tests {
+		acct, identity, ok := FindAccount(accounts, tt.key)
+		if ok != tt.wantOK || (ok && (acct != tt.wantAccount || identity != tt.wantIdentity)) {
+			t.Errorf("FindAccount(%q) = %p, %p, %v; want %p, %p, %v", tt.key, acct, identity, ok, tt.wantAccount, tt.wantIdentity, tt.wantOK)
+		}
+	}
+}
+
+func TestTokenKeys(t *testing.T) {
+	cfg := &Config{Accounts: map[string]*Account{
+		"work":   {Identities: map[string]*Identity{"bob": {}, "alice": {}}},
+		"work-x": {},
+		"home":   nil,
+		"works":  {},
+	}}
+	want := []string{"home", "work", "work/alice", "work/bob", "work-x", "works"}
+	if got := cfg.TokenKeys(); !slices.Equal(got, want) {
+		t.Errorf("TokenKeys() = %q, want %q", got, want)
+	}
+	if got := (&Config{}).TokenKeys(); len(got) != 0 {
+		t.Errorf("TokenKeys() without accounts = %q", got)
+	}
+}
+
+func TestAuthParams(t *testing.T) {
+	acct := &Account{
+		AuthURIFields: map[string]string{"prompt": "consent", "login_hint": "default@example.com"},
+		Resource:      []string{"https://api.example.com", "https://graph.example.com"},
+		Audience:      []string{"api"},
+	}
+	tests := []struct {
+		identity *Identity
+		want     string
+	}{
+		{nil, "audience=api&login_hint=default%40example.com&prompt=consent&resource=https%3A%2F%2Fapi.example.com&resource=https%3A%2F%2Fgraph.example.com"},
+		{&Identity{}, "audience=api&login_hint=default%40example.com&prompt=consent&resource=https%3A%2F%2Fapi.example.com&resource=https%3A%2F%2Fgraph.example.com"},
+		{&Identity{LoginHint: "alice@example.com"}, "audience=api&login_hint=alice%40example.com&prompt=consent&resource=https%3A%2F%2Fapi.example.com&resource=https%3A%2F%2Fgraph.example.com"},
+	}
+	for _, tt := range tests {
+		if got := AuthParams(acct, tt.identity).Encode(); got != tt.want {
+			t.Errorf("AuthParams(%+v) = %s, want %s", tt.identity, got, tt.want)
+		}
+	}
+	if got := AuthParams(&Account{}, nil); len(got) != 0 {
+		t.Errorf("AuthParams without settings = %v", got)
+	}
+}
+
+func TestTokenParams(t *testing.T) {
+	tests := []struct {
+		acct *Account
+		want string
+	}{
+		{&Account{}, ""},
+		{&Account{TokenURIFields: map[string]string{"tenant": "example"}}, "tenant=example"},
+		{&Account{Resource: []string{"urn:a", "urn:b"}, Audience: []string{"x", "y"}}, "audience=x&audience=y&resource=urn%3Aa&resource=urn%3Ab"},
+		{&Account{TokenURIFields: map[string]string{"audience": "field"}, Audience: []string{"list"}}, "audience=field&audience=list"},
+	}
+	for _, tt := range tests {
+		if got := TokenParams(tt.acct).Encode(); got != tt.want {
+			t.Errorf("TokenParams(%+v) = %s, want %s", tt.acct, got, tt.want)
+		}
+	}
+}
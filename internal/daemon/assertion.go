@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/jwt"
+	"golang.org/x/oauth2"
+)
+
+const jwtBearerGrant = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// AssertionToken requests a token for the jwt_bearer account with a freshly signed
+// assertion (RFC 7523). The issuer and key ID default to those of a service account
+// JSON key, the subject to the issuer and the assertion audience to the token
+// endpoint. Like other accounts it sends its token_uri_fields, resource and audience
+// as request parameters.
+func AssertionToken(account string, cfg *config.Config, httpClient *http.Client) (*oauth2.Token, error) {
+	acct, ok := cfg.LookupAccount(account)
+	if !ok || acct == nil {
+		return nil, ErrAccountNotFound
+	}
+	key, err := jwt.LoadKeyFile(acct.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("key_file: %w", err)
+	}
+	claims := jwt.Claims{
+		Issuer:   cmp.Or(acct.Issuer, key.Issuer),
+		Audience: acct.AssertionAudience,
+		Scope:    strings.Join(acct.Scopes.List, " "),
+	}
+	claims.Subject = cmp.Or(acct.Subject, claims.Issuer)
+	if len(claims.Audience) == 0 {
+		claims.Audience = []string{acct.TokenURI}
+	}
+	assertion, err := jwt.Sign(key.Signer, cmp.Or(acct.KeyID, key.KeyID), claims)
+	if err != nil {
+		return nil, fmt.Errorf("sign assertion: %w", err)
+	}
+
+	params := config.TokenParams(acct)
+	params.Set("grant_type", jwtBearerGrant)
+	params.Set("assertion", assertion)
+	return requestGrant(account, cfg, acct, params, httpClient)
+}
+
+// isAssertion reports whether key is a jwt_bearer account, whose tokens the daemon
+// obtains on its own and refreshes in the background like refresh tokens.
+func isAssertion(cfg *config.Config, key string) bool {
+	acct, ok := cfg.LookupAccount(key)
+	return ok && acct.GrantType() == config.GrantJWTBearer
+}
+
+// applyKeyFileDefaults sets the token_uri of a jwt_bearer account that has none to
+// the token_uri of its service account JSON key. A key file that cannot be read is
+// left for validateJWTBearer to report.
+func applyKeyFileDefaults(cfg *config.Config) {
+	for _, acct := range cfg.Accounts {
+		if acct == nil || acct.TokenURI != "" || acct.KeyFile == "" || acct.GrantType() != config.GrantJWTBearer {
+			continue
+		}
+		if key, err := jwt.LoadKeyFile(acct.KeyFile); err == nil {
+			acct.TokenURI = key.TokenURI
+		}
+	}
+}
+
+// validateJWTBearer checks that the key file of a jwt_bearer account can be loaded
+// and that it has an issuer.
+func validateJWTBearer(name string, acct *config.Account) error {
+	if acct.KeyFile == "" {
+		return fmt.Errorf("account %q uses jwt_bearer but has no key_file", name)
+	}
+	key, err := jwt.LoadKeyFile(acct.KeyFile)
+	if err != nil {
+		return fmt.Errorf("account %q key_file: %w", name, err)
+	}
+	if acct.Issuer == "" && key.Issuer == "" {
+		return fmt.Errorf("account %q uses jwt_bearer but has no issuer", name)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+)
+
+// writeServiceAccountKey writes a service account JSON key with a new RSA key and
+// fields, and returns its path.
+func writeServiceAccountKey(t *testing.T, fields map[string]string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := map[string]string{
+		"type":        "service_account",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+	for name, value := range fields {
+		account[name] = value
+	}
+	keyJSON, err := json.Marshal(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "service-account.json")
+	if err := os.WriteFile(keyFile, keyJSON, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+// assertionClaims decodes the claims of the assertion sent with r.
+func assertionClaims(r *http.Request) (map[string]any, error) {
+	parts := strings.Split(r.Form.Get("assertion"), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("assertion is not a JWT: %q", r.Form.Get("assertion"))
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	return claims, json.Unmarshal(data, &claims)
+}
+
+func TestAssertionTokenWithServiceAccountKey(t *testing.T) {
+	keyFile := writeServiceAccountKey(t, map[string]string{
+		"private_key_id": "key-1",
+		"client_email":   "robot@project.example",
+	})
+
+	var requests atomic.Int32
+	var tokenURI string
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		requests.Add(1)
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.Form.Has("client_id") ||
+			r.Form.Get("audience") != "https://api.example" {
+			t.Errorf("unexpected assertion request: %v", r.Form)
+		}
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("assertion is not a JWT: %q", r.Form.Get("assertion"))
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var header, claims map[string]any
+		for i, v := range []*map[string]any{&header, &claims} {
+			data, err := base64.RawURLEncoding.DecodeString(parts[i])
+			if err != nil {
+				t.Error(err)
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			if err := json.Unmarshal(data, v); err != nil {
+				t.Error(err)
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+		}
+		if header["alg"] != "RS256" || header["kid"] != "key-1" {
+			t.Errorf("unexpected assertion header: %v", header)
+		}
+		if claims["iss"] != "robot@project.example" || claims["sub"] != "admin@example.com" ||
+			claims["aud"] != tokenURI || claims["scope"] != "read write" {
+			t.Errorf("unexpected assertion claims: %v", claims)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "service", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenEndpoint.Close()
+	tokenURI = tokenEndpoint.URL + "/token"
+
+	cfg := &config.Config{
+		Accounts: map[string]*config.Account{
+			"robot": {
+				TokenURI: tokenURI, Grant: config.GrantJWTBearer, KeyFile: keyFile,
+				Subject: "admin@example.com", Scopes: config.Scopes{List: []string{"read", "write"}},
+				Audience: []string{"https://api.example"},
+			},
+		},
+	}
+	d := &Daemon{Config: cfg, TokenStore: storage.NewMemoryStore(), HTTPClient: tokenEndpoint.Client()}
+	for range 2 {
+		token, err := d.accessToken("robot", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "service" {
+			t.Fatalf("access token = %q, want service", token.AccessToken)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("token endpoint called %d times, want 1", got)
+	}
+}
+
+func TestAssertionTokenAssertionAudience(t *testing.T) {
+	keyFile := writeServiceAccountKey(t, map[string]string{"client_email": "robot@project.example"})
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		claims, err := assertionClaims(r)
+		if err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if got := fmt.Sprint(claims["aud"]); got != "[https://idp.example https://api.example]" {
+			t.Errorf("aud claim = %s, want both assertion_audience entries", got)
+		}
+		if r.Form.Has("audience") {
+			t.Errorf("assertion_audience sent as a request parameter: %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "service", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenEndpoint.Close()
+
+	cfg := &config.Config{
+		Accounts: map[string]*config.Account{
+			"robot": {
+				TokenURI: tokenEndpoint.URL, Grant: config.GrantJWTBearer, KeyFile: keyFile,
+				AssertionAudience: []string{"https://idp.example", "https://api.example"},
+			},
+		},
+	}
+	if _, err := AssertionToken("robot", cfg, tokenEndpoint.Client()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyKeyFileDefaultsTokenURI(t *testing.T) {
+	withTokenURI := writeServiceAccountKey(t, map[string]string{
+		"client_email": "robot@project.example",
+		"token_uri":    "https://oauth2.example/token",
+	})
+	withoutTokenURI := writeServiceAccountKey(t, map[string]string{"client_email": "robot@project.example"})
+
+	tests := []struct {
+		name    string
+		acct    *config.Account
+		want    string
+		wantErr string
+	}{
+		{"from key file", &config.Account{Grant: config.GrantJWTBearer, KeyFile: withTokenURI}, "https://oauth2.example/token", ""},
+		{"configured wins", &config.Account{Grant: config.GrantJWTBearer, KeyFile: withTokenURI, TokenURI: "https://idp.example/token"}, "https://idp.example/token", ""},
+		{"key file without one", &config.Account{Grant: config.GrantJWTBearer, KeyFile: withoutTokenURI}, "", `account "robot" has no token_uri and its key_file does not name one`},
+		{"unreadable key file", &config.Account{Grant: config.GrantJWTBearer, KeyFile: filepath.Join(t.TempDir(), "missing.json")}, "", `account "robot" key_file: `},
+		{"not jwt_bearer", &config.Account{KeyFile: withTokenURI}, "", `account "robot" is missing required fields`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Accounts: map[string]*config.Account{"robot": tt.acct}}
+			applyKeyFileDefaults(cfg)
+			err := validateConfig(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("validateConfig = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.acct.TokenURI != tt.want {
+				t.Errorf("token_uri = %q, want %q", tt.acct.TokenURI, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/vybraan/vygrant/internal/audit"
+	"golang.org/x/oauth2"
+)
+
+// auditLog receives token access entries; nil when audit_log is not configured.
+var auditLog *audit.Log
+
+// auditedCommands are the socket commands that read or change tokens.
+var auditedCommands = map[string]bool{
+	"get-token":      true,
+	"delete-token":   true,
+	"refresh-token":  true,
+	"dump-tokens":    true,
+	"restore-tokens": true,
+	"login":          true,
+	"proxy-env":      true,
+}
+
+func recordAudit(entry audit.Entry) {
+	if auditLog == nil {
+		return
+	}
+	if err := auditLog.Record(entry); err != nil {
+		slog.Error("audit log write failed", "event", entry.Event, "account", entry.Account, "error", err)
+	}
+}
+
+// auditResult converts an error into the Result and Error fields of an entry.
+func auditResult(entry audit.Entry, err error) audit.Entry {
+	entry.Result = "ok"
+	if err != nil {
+		entry.Result = "error"
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// auditedToken returns d.accessToken recording each read in the audit log with
+// source, for the token consumers that do not go through the socket.
+func (d *Daemon) auditedToken(source string) func(account string, force bool) (*oauth2.Token, error) {
+	return func(account string, force bool) (*oauth2.Token, error) {
+		token, err := d.accessToken(account, force)
+		event := "get-token"
+		if force {
+			event = "refresh-token"
+		}
+		recordAudit(auditResult(audit.Entry{Event: event, Source: source, Account: account}, err))
+		return token, err
+	}
+}
+
+// auditAuthEvent is the auth.EventHook that records OAuth callbacks.
+func auditAuthEvent(account, event string, err error) {
+	if event == "auth_started" {
+		return
+	}
+	recordAudit(auditResult(audit.Entry{Event: "oauth_callback", Source: "http", Account: account}, err))
+}
+
+// resultConn remembers the first error a command writes back to the client.
+type resultConn struct {
+	net.Conn
+	errMsg string
+}
+
+func (c *resultConn) Write(p []byte) (int, error) {
+	if c.errMsg == "" && bytes.HasPrefix(p, []byte("ERROR: ")) {
+		c.errMsg = strings.TrimSpace(string(bytes.TrimPrefix(p, []byte("ERROR: "))))
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *resultConn) entry(command string, fields []string, caller *audit.Caller) audit.Entry {
+	entry := audit.Entry{Event: command, Source: "socket", Caller: caller, Result: "ok"}
+	if len(fields) > 1 {
+		entry.Account = fields[1]
+	}
+	if c.errMsg != "" {
+		entry.Result = "error"
+		entry.Error = c.errMsg
+	}
+	return entry
+}
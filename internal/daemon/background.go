@@ -1,19 +1,19 @@
 package daemon
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/vybraan/vygrant/internal/config"
 	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
 )
 
-const (
-	checkInterval   = 30 * time.Minute
-	expiryThreshold = 10 * time.Minute
-)
+// failureRetryDelay is the first delay before a failed background refresh is tried
+// again; it doubles with each consecutive failure up to the check interval.
+const failureRetryDelay = time.Minute
 
 var bgWg sync.WaitGroup
 
@@ -21,18 +21,197 @@ func WaitForBackgroundTasks() {
 	bgWg.Wait()
 }
 
-func StartBackgroundTasks(cfg *config.Config, tokenStore storage.TokenStore, httpClient *http.Client, stopCh <-chan struct{}) {
+// scheduler keeps one timer per account that fires when the account's token is due
+// for refresh, or after its check interval, whichever comes first.
+type scheduler struct {
+	cfg        *config.Config
+	store      storage.TokenStore
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	timers  map[string]*time.Timer
+	warned  map[string]time.Time
+	stopped bool
+	running sync.WaitGroup
+}
+
+func newScheduler(cfg *config.Config, store storage.TokenStore, httpClient *http.Client) *scheduler {
+	return &scheduler{
+		cfg:        cfg,
+		store:      store,
+		httpClient: httpClient,
+		timers:     map[string]*time.Timer{},
+		warned:     map[string]time.Time{},
+	}
+}
+
+// Run schedules every configured account and blocks until stopCh is closed. Resumes
+// from suspend and network changes trigger an immediate check of all accounts.
+func (s *scheduler) Run(stopCh <-chan struct{}) {
 	defer bgWg.Done()
-	ticker := time.NewTicker(checkInterval)
-	defer ticker.Stop()
+	s.scheduleAll()
 
-	for {
+	wake := make(chan string, 1)
+	go watchClock(wake, stopCh)
+	go watchNetwork(wake, stopCh)
+	for running := true; running; {
 		select {
-		case <-ticker.C:
-			checkExpiringTokens(cfg, tokenStore, httpClient)
+		case reason := <-wake:
+			s.wakeUp(reason, stopCh)
 		case <-stopCh:
-			log.Println("Stopping background tasks...")
+			running = false
+		}
+	}
+	slog.Info("stopping background tasks")
+
+	s.mu.Lock()
+	s.stopped = true
+	for account, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, account)
+	}
+	s.mu.Unlock()
+	s.running.Wait()
+}
+
+// Changed is the storage.ChangeFunc that reschedules an account whenever its token
+// is set, deleted or restored.
+func (s *scheduler) Changed(account, event string) {
+	if account == "*" {
+		s.scheduleAll()
+		return
+	}
+	if onDemand(s.cfg, account) {
+		return
+	}
+	if _, ok := s.cfg.LookupAccount(account); !ok {
+		return
+	}
+	s.schedule(account)
+}
+
+func (s *scheduler) scheduleAll() {
+	for _, account := range s.cfg.TokenKeys() {
+		if !onDemand(s.cfg, account) {
+			s.schedule(account)
+		}
+	}
+}
+
+// onDemand reports whether the token of key is requested when it is needed rather
+// than refreshed in the background: scope sets and token exchange accounts.
+func onDemand(cfg *config.Config, key string) bool {
+	if _, set := config.SplitScopeKey(key); set != "" {
+		return true
+	}
+	return isExchanged(cfg, key)
+}
+
+// schedule replaces the timer of account with one for its next check. Accounts
+// without a refreshable token only get a timer to publish expiring_soon once.
+func (s *scheduler) schedule(account string) {
+	now := time.Now()
+	token, err := s.store.Get(account)
+	if err != nil || token == nil {
+		token = nil
+	}
+	refreshable := token != nil && (token.RefreshToken != "" || isAssertion(s.cfg, account))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if timer, ok := s.timers[account]; ok {
+		timer.Stop()
+		delete(s.timers, account)
+	}
+	if token == nil {
+		return
+	}
+	if !refreshable {
+		if token.Expiry.IsZero() || !token.Expiry.After(now) || s.warned[account].Equal(token.Expiry) {
 			return
 		}
+		delay := max(token.Expiry.Add(-refreshLead(s.cfg, account, token)).Sub(now), 0)
+		s.timers[account] = time.AfterFunc(delay, func() { s.fire(account) })
+		return
 	}
+
+	interval := s.cfg.AccountCheckInterval(account)
+	at := now.Add(interval)
+	if !token.Expiry.IsZero() {
+		if due := token.Expiry.Add(-refreshLead(s.cfg, account, token)); due.Before(at) {
+			at = due
+		}
+	}
+	if state, ok := refreshStates.get(account); ok && state.Failures > 0 {
+		retry := min(failureRetryDelay<<min(state.Failures-1, 16), interval)
+		if next := state.LastAttempt.Add(retry); next.After(at) {
+			at = next
+		}
+	}
+
+	delay := max(at.Sub(now), 0)
+	s.timers[account] = time.AfterFunc(delay, func() { s.fire(account) })
+	slog.Debug("token check scheduled", "account", account, "in", delay.Round(time.Second))
+}
+
+// refreshLead is refresh_before for account, capped at half the lifetime of token so
+// that short-lived tokens are not refreshed as soon as they are issued. The lifetime
+// is the expires_in the token was issued with; tokens issued without one are not
+// capped.
+func refreshLead(cfg *config.Config, account string, token *oauth2.Token) time.Duration {
+	lead := cfg.AccountRefreshBefore(account)
+	if lifetime := time.Duration(token.ExpiresIn) * time.Second; lifetime > 0 && lead > lifetime/2 {
+		lead = lifetime / 2
+	}
+	return lead
+}
+
+func (s *scheduler) fire(account string) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.running.Add(1)
+	s.mu.Unlock()
+	defer s.running.Done()
+
+	start := time.Now()
+	refreshed, _ := checkAccount(s.cfg, s.store, s.httpClient, account)
+	duration := time.Since(start)
+	backgroundCheckDuration.Observe(duration.Seconds())
+	slog.Debug("background token check finished", "account", account, "duration", duration)
+	if !refreshed {
+		s.warnExpiring(account)
+		// A successful refresh reschedules through Changed; anything else must do it
+		// here so the account is checked again.
+		s.schedule(account)
+	}
+}
+
+// warnExpiring publishes expiring_soon once per token when the token of account
+// expires within its refreshLead and was not refreshed.
+func (s *scheduler) warnExpiring(account string) {
+	token, err := s.store.Get(account)
+	if err != nil || token == nil || token.Expiry.IsZero() {
+		return
+	}
+	now := time.Now()
+	if !token.Expiry.After(now) || token.Expiry.After(now.Add(refreshLead(s.cfg, account, token))) {
+		return
+	}
+	s.mu.Lock()
+	if s.warned[account].Equal(token.Expiry) {
+		s.mu.Unlock()
+		return
+	}
+	s.warned[account] = token.Expiry
+	s.mu.Unlock()
+
+	expiry := token.Expiry
+	slog.Info("token expiring soon", "account", account, "expiry", expiry)
+	events.publish(Event{Type: EventExpiringSoon, Account: account, Expiry: &expiry})
 }
@@ -0,0 +1,172 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshLead(t *testing.T) {
+	cfg := &config.Config{
+		RefreshBefore: 10 * time.Minute,
+		Accounts: map[string]*config.Account{
+			"short": {},
+			"own":   {RefreshBefore: 40 * time.Minute},
+		},
+	}
+	tests := []struct {
+		account   string
+		expiresIn int64
+		want      time.Duration
+	}{
+		{"short", 300, 150 * time.Second},
+		{"short", 3600, 10 * time.Minute},
+		{"short", 0, 10 * time.Minute},
+		{"own", 3600, 30 * time.Minute},
+		{"own", 7200, 40 * time.Minute},
+	}
+	for _, tt := range tests {
+		token := &oauth2.Token{ExpiresIn: tt.expiresIn}
+		if got := refreshLead(cfg, tt.account, token); got != tt.want {
+			t.Errorf("refreshLead(%s, expires_in %d) = %v, want %v", tt.account, tt.expiresIn, got, tt.want)
+		}
+	}
+}
+
+// countingEndpoint issues a new token with expires_in 3600 for every request.
+func countingEndpoint(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "new-access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestCheckExpiringTokensUsesSchedulerLead(t *testing.T) {
+	// A 5-minute token is due 2.5 minutes before expiry, not at refresh_before.
+	tests := []struct {
+		remaining time.Duration
+		want      int32
+	}{
+		{4 * time.Minute, 0},
+		{2 * time.Minute, 1},
+	}
+	for _, tt := range tests {
+		endpoint, requests := countingEndpoint(t)
+		store := storage.NewMemoryStore()
+		if err := store.Set("short", &oauth2.Token{
+			AccessToken:  "old",
+			RefreshToken: "refresh",
+			ExpiresIn:    300,
+			Expiry:       time.Now().Add(tt.remaining),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &config.Config{
+			RefreshBefore: 10 * time.Minute,
+			Accounts:      map[string]*config.Account{"short": {TokenURI: endpoint.URL}},
+		}
+
+		checkExpiringTokens(cfg, store, endpoint.Client())
+
+		if got := requests.Load(); got != tt.want {
+			t.Errorf("%v before expiry: %d refreshes, want %d", tt.remaining, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerRefreshesDueToken(t *testing.T) {
+	endpoint, requests := countingEndpoint(t)
+	store := storage.NewObservedStore(storage.NewMemoryStore())
+	// Seen mid-life: 20 of 60 minutes left is within a 30-minute refresh_before,
+	// which the lifetime of 60 minutes does not cap.
+	if err := store.Set("mid", &oauth2.Token{
+		AccessToken:  "old",
+		RefreshToken: "refresh",
+		ExpiresIn:    3600,
+		Expiry:       time.Now().Add(20 * time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		RefreshBefore: 30 * time.Minute,
+		Accounts:      map[string]*config.Account{"mid": {TokenURI: endpoint.URL}},
+	}
+	s := newScheduler(cfg, store, endpoint.Client())
+	store.Observe(s.Changed)
+	stopCh := make(chan struct{})
+	bgWg.Add(1)
+	go s.Run(stopCh)
+	defer func() {
+		close(stopCh)
+		WaitForBackgroundTasks()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		token, err := store.Get("mid")
+		if err == nil && token.AccessToken == "new-access" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token was not refreshed; token endpoint saw %d requests", requests.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The new token is not due for 50 minutes, so it is not refreshed again.
+	time.Sleep(50 * time.Millisecond)
+	if got := requests.Load(); got != 1 {
+		t.Errorf("token endpoint saw %d requests, want 1", got)
+	}
+}
+
+func TestSchedulerWarnsBeforeUnrefreshableTokenExpires(t *testing.T) {
+	ch, cancel := events.subscribe()
+	defer cancel()
+
+	store := storage.NewMemoryStore()
+	expiry := time.Now().Add(time.Minute)
+	if err := store.Set("noreft", &oauth2.Token{AccessToken: "old", ExpiresIn: 3600, Expiry: expiry}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Accounts: map[string]*config.Account{"noreft": {}}}
+	s := newScheduler(cfg, store, nil)
+	s.schedule("noreft")
+	defer func() {
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-ch:
+			if e.Type != EventExpiringSoon || e.Account != "noreft" {
+				continue
+			}
+			if e.Expiry == nil || !e.Expiry.Equal(expiry) {
+				t.Errorf("expiry = %v, want %v", e.Expiry, expiry)
+			}
+			return
+		case <-timeout:
+			t.Fatal("expiring_soon was not published")
+		}
+	}
+}
@@ -5,14 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net"
-	"os"
-	"path"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/notify"
 	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
 )
 
 func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scanner) {
@@ -33,27 +34,48 @@ func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scann
 		}
 
 		var accountList strings.Builder
-		for name := range d.Config.Accounts {
-			accountList.WriteString(name + "\n")
+		for _, key := range d.Config.TokenKeys() {
+			if _, identity := config.SplitTokenKey(key); identity != "" {
+				accountList.WriteString("  ")
+			}
+			accountList.WriteString(key + "\n")
 		}
 		writeResponse(conn, accountList.String())
 
 	case "status":
-		var status []string
-		for name := range d.Config.Accounts {
-			if _, err := d.TokenStore.Get(name); err != nil {
-				status = append(status, fmt.Sprintf("%s: token missing or expired", name))
-			} else {
-				status = append(status, fmt.Sprintf("%s: token valid", name))
+		asJSON := false
+		account := ""
+		for _, arg := range parts[1:] {
+			switch {
+			case arg == "--json":
+				asJSON = true
+			case account == "" && !strings.HasPrefix(arg, "-"):
+				account = arg
+			default:
+				writeError(conn, "Invalid arguments. Usage: status [--json] [account_name]")
+				return
+			}
+		}
+		statuses, err := d.accountStatuses(account)
+		if err != nil {
+			writeError(conn, "Could not get status for '%s': %v", account, err)
+			return
+		}
+		if asJSON {
+			data, err := json.Marshal(statuses)
+			if err != nil {
+				writeError(conn, "Failed to encode status: %v", err)
+				return
 			}
+			conn.Write(data)
+			return
 		}
-		writeResponse(conn, strings.Join(status, "\n"))
+		writeResponse(conn, "%s", FormatStatus(statuses))
 
 	case "info":
 
-		home, _ := os.UserHomeDir()
 		publicKey := d.PublicKey
-		if !isListenerEnabled(d.Config.HTTPSListen) {
+		if !IsListenerEnabled(d.Config.HTTPSListen) {
 			publicKey = "disabled"
 		}
 		tokenBackend := tokenBackendDescription(d.TokenStore)
@@ -64,7 +86,7 @@ func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scann
 		info := fmt.Sprintf(
 			"Socket path: %s\nConfig file: %s\nToken storage: %s%s\nServer running on:\n  HTTP Port: %s\n  HTTPS Port: %s\nHTTPS public key: %s",
 			SocketPath(),
-			path.Join(home, VYGRANT_CONFIG),
+			ConfigPath(),
 			tokenBackend,
 			migrationLine,
 			d.Config.HTTPListen,
@@ -73,15 +95,45 @@ func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scann
 		)
 		writeResponse(conn, info)
 
+	case "proxy-env":
+		env, err := d.proxyEnv()
+		if err != nil {
+			writeError(conn, "%v", err)
+			return
+		}
+		writeResponse(conn, "%s", env)
+
 	case "get-token":
-		if !expectArgs(conn, parts, 2, "get-token <account_name>") {
+		if len(parts) < 2 || len(parts) > 3 {
+			writeError(conn, "Invalid arguments. Usage: get-token <account_name> [--wait[=timeout]]")
 			return
 		}
+		var wait time.Duration
+		if len(parts) == 3 {
+			parsed, err := parseWait(parts[2], d.Config.LoginWait())
+			if err != nil {
+				writeError(conn, "Invalid arguments: %v. Usage: get-token <account_name> [--wait[=timeout]]", err)
+				return
+			}
+			wait = parsed
+		}
 
 		account := parts[1]
+		if onDemand(d.Config, account) || isAssertion(d.Config, account) {
+			d.writeDerivedToken(conn, account, wait)
+			return
+		}
 		token, err := d.TokenStore.Get(account)
 
 		if err != nil {
+			if wait > 0 {
+				d.writeTokenAfterLogin(conn, account, wait)
+				return
+			}
+			if token, ok := d.autoLogin(conn, account); ok {
+				writeResponse(conn, "%s", token.AccessToken)
+				return
+			}
 			authLink := d.authURL(account)
 			writeError(conn, "Could not retrieve token for '%s': %v. Please authenticate. Go to: %s", account, err, authLink)
 			return
@@ -89,21 +141,34 @@ func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scann
 
 		// Auto-refresh token if expired
 		if err == nil && token.Expiry.Before(time.Now()) && token.RefreshToken != "" {
-			newToken, err := RefreshToken(account, d.Config, token, d.HTTPClient)
+			newToken, err := awaitRefresh(socketRefreshWait, func() (*oauth2.Token, error) {
+				return refreshAccount(account, d.Config, d.TokenStore, d.HTTPClient, token)
+			})
 			if err != nil {
-
-				Notify("vygrant - auto refresh failed", fmt.Sprintf("Token for '%s' could not be refreshed and has been deleted. Please re-authenticate.", account))
-				writeError(conn, "Failed to auto refresh token for '%s': %v", account, err)
-				if err := d.TokenStore.Delete(account); err != nil {
-					log.Printf("failed to delete stale token for %s: %v", account, err)
+				notifyRefreshFailure(account, err)
+				if isRefreshRejected(err) && wait > 0 {
+					d.writeTokenAfterLogin(conn, account, wait)
+					return
+				}
+				if isRefreshRejected(err) {
+					if token, ok := d.autoLogin(conn, account); ok {
+						writeResponse(conn, "%s", token.AccessToken)
+						return
+					}
+				}
+				if refreshErrorKindOf(err) == refreshRotated {
+					writeError(conn, "Failed to auto refresh token for '%s': refresh token was rotated elsewhere (%v). Please authenticate at: %s", account, err, d.authURL(account))
+					return
 				}
+				if isRefreshRejected(err) {
+					writeError(conn, "Failed to auto refresh token for '%s': %v. Please authenticate at: %s", account, err, d.authURL(account))
+					return
+				}
+				writeError(conn, "Failed to auto refresh token for '%s' (%s): %v", account, refreshErrorKindOf(err), err)
 				return
 			}
-			if err := d.TokenStore.Set(account, newToken); err != nil {
-				log.Printf("failed to save refreshed token for %s: %v", account, err)
-			}
 			token = newToken
-			Notify("vygrant - token refreshed", fmt.Sprintf("Token for '%s' successfully refreshed.", account))
+			notifyRefreshed(account)
 		}
 
 		writeResponse(conn, token.AccessToken)
@@ -127,30 +192,62 @@ func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scann
 		}
 
 		account := parts[1]
+		if onDemand(d.Config, account) || isAssertion(d.Config, account) {
+			if _, err := awaitRefresh(socketRefreshWait, func() (*oauth2.Token, error) {
+				return d.accessToken(account, true)
+			}); err != nil {
+				writeError(conn, "Failed to refresh token for '%s' (%s): %v", account, refreshErrorKindOf(err), err)
+				return
+			}
+			writeResponse(conn, "Token for '%s' refreshed", account)
+			return
+		}
 		token, err := d.TokenStore.Get(account)
 
 		if err != nil || token.RefreshToken == "" {
+			if _, ok := d.autoLogin(conn, account); ok {
+				writeResponse(conn, "Token for '%s' obtained by login", account)
+				return
+			}
 			authLink := d.authURL(account)
-			Notify("vygrant - no refresh token", fmt.Sprintf("No refresh token for '%s'. Authenticate at: %s", account, authLink))
+			Notify(notify.Notification{
+				Event: notifyAuthRequired, Account: account, Severity: notify.Warning,
+				Title:   "vygrant - no refresh token",
+				Message: fmt.Sprintf("No refresh token for '%s'. Authenticate at: %s", account, authLink),
+			})
 			writeError(conn, "No refresh token available for '%s'. Please authenticate at: %s", account, authLink)
 			return
 		}
 
-		newToken, err := RefreshToken(account, d.Config, token, d.HTTPClient)
-		if err != nil {
-			writeError(conn, "Failed to refresh token for '%s': %v", account, err)
-			if err := d.TokenStore.Delete(account); err != nil {
-				log.Printf("failed to delete stale token for %s: %v", account, err)
+		if _, err := awaitRefresh(socketRefreshWait, func() (*oauth2.Token, error) {
+			return refreshAccount(account, d.Config, d.TokenStore, d.HTTPClient, token)
+		}); err != nil {
+			if isRefreshRejected(err) {
+				if _, ok := d.autoLogin(conn, account); ok {
+					writeResponse(conn, "Token for '%s' obtained by login", account)
+					return
+				}
+			}
+			if refreshErrorKindOf(err) == refreshRotated {
+				notifyRefreshFailure(account, err)
+				writeError(conn, "Failed to refresh token for '%s': refresh token was rotated elsewhere (%v). Please authenticate at: %s", account, err, d.authURL(account))
+				return
+			}
+			if isRefreshRejected(err) {
+				writeError(conn, "Failed to refresh token for '%s': %v. The refresh token was rejected and has been deleted; please authenticate at: %s", account, err, d.authURL(account))
+				return
 			}
+			writeError(conn, "Failed to refresh token for '%s' (%s): %v", account, refreshErrorKindOf(err), err)
 			return
 		}
-		if err := d.TokenStore.Set(account, newToken); err != nil {
-			log.Printf("failed to save refreshed token for %s: %v", account, err)
-		}
-		Notify("vygrant - token refreshed", fmt.Sprintf("Token for '%s' successfully refreshed.", account))
+		notifyRefreshed(account)
 		writeResponse(conn, "Token for '%s' refreshed", account)
 	case "dump-tokens":
-		dumper, ok := d.TokenStore.(storage.TokenDumper)
+		if len(parts) > 2 {
+			writeError(conn, "Invalid arguments. Usage: dump-tokens [account_name]")
+			return
+		}
+		dumper, ok := storage.Dumper(d.TokenStore)
 		if !ok {
 			writeError(conn, "Token store does not support dump")
 			return
@@ -160,9 +257,15 @@ func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scann
 			writeError(conn, "Failed to dump tokens: %v", err)
 			return
 		}
+		if len(parts) == 2 {
+			if data, err = filterDump(data, parts[1]); err != nil {
+				writeError(conn, "Failed to dump tokens: %v", err)
+				return
+			}
+		}
 		conn.Write(data)
 	case "restore-tokens":
-		dumper, ok := d.TokenStore.(storage.TokenDumper)
+		dumper, ok := storage.Dumper(d.TokenStore)
 		if !ok {
 			writeError(conn, "Token store does not support restore")
 			return
@@ -178,17 +281,54 @@ func (d *Daemon) HandleCommand(conn net.Conn, input string, scanner *bufio.Scann
 		}
 		writeResponse(conn, "Tokens restored")
 
+	case "subscribe":
+		if len(parts) > 2 {
+			writeError(conn, "Invalid arguments. Usage: subscribe [account_name]")
+			return
+		}
+		account := ""
+		if len(parts) == 2 {
+			account = parts[1]
+			if _, ok := d.Config.LookupAccount(account); !ok {
+				writeError(conn, "Could not subscribe to '%s': %v", account, ErrAccountNotFound)
+				return
+			}
+		}
+		streamEvents(conn, scanner, account)
+
+	case "login":
+		if len(parts) < 2 || len(parts) > 3 {
+			writeError(conn, "Invalid arguments. Usage: login <account_name> [timeout]")
+			return
+		}
+		account := parts[1]
+		if _, ok := d.Config.LookupAccount(account); !ok {
+			writeError(conn, "Could not log in to '%s': %v", account, ErrAccountNotFound)
+			return
+		}
+		timeout := d.Config.LoginWait()
+		if len(parts) == 3 {
+			parsed, err := time.ParseDuration(parts[2])
+			if err != nil || parsed <= 0 {
+				writeError(conn, "Invalid timeout '%s'", parts[2])
+				return
+			}
+			timeout = parsed
+		}
+		d.streamLogin(conn, scanner, account, timeout)
+
 	default:
 		writeError(conn, "Unknown command '%s'", parts[0])
 	}
 }
 
 func (d *Daemon) authURL(account string) string {
-	httpsEnabled := isListenerEnabled(d.Config.HTTPSListen)
-	httpEnabled := isListenerEnabled(d.Config.HTTPListen)
+	account = d.loginAccount(account)
+	httpsEnabled := IsListenerEnabled(d.Config.HTTPSListen)
+	httpEnabled := IsListenerEnabled(d.Config.HTTPListen)
 	scheme := "https"
 	port := d.Config.HTTPSListen
-	if acct, ok := d.Config.Accounts[account]; ok {
+	if acct, ok := d.Config.LookupAccount(account); ok {
 		redirect := strings.ToLower(strings.TrimSpace(acct.RedirectURI))
 		if strings.HasPrefix(redirect, "http://") {
 			scheme = "http"
@@ -206,13 +346,37 @@ func (d *Daemon) authURL(account string) string {
 		scheme = "https"
 		port = d.Config.HTTPSListen
 	}
-	return fmt.Sprintf("%s://localhost:%s/auth?account=%s", scheme, port, account)
+	return fmt.Sprintf("%s://localhost:%s/auth?account=%s", scheme, port, url.QueryEscape(account))
+}
+
+// unwrapStore strips the decorating stores (observers, instrumentation) and returns
+// the store that actually holds the tokens.
+func unwrapStore(store storage.TokenStore) storage.TokenStore {
+	for {
+		switch typed := store.(type) {
+		case *storage.ObservedStore:
+			store = typed.Inner()
+		case *storage.InstrumentedStore:
+			store = typed.Inner()
+		default:
+			return store
+		}
+	}
+}
+
+// refreshBackend names the backend that holds refresh tokens.
+func refreshBackend(store storage.TokenStore) string {
+	store = unwrapStore(store)
+	if split, ok := store.(*storage.SplitStore); ok {
+		store = unwrapStore(split.RefreshStore())
+	}
+	return tokenBackendDescription(store)
 }
 
 func tokenBackendDescription(store storage.TokenStore) string {
-	switch typed := store.(type) {
+	switch typed := unwrapStore(store).(type) {
 	case *storage.SplitStore:
-		switch typed.RefreshStore().(type) {
+		switch unwrapStore(typed.RefreshStore()).(type) {
 		case *storage.KeyringStore:
 			return "split (access: memory, refresh: keyring)"
 		case *storage.PassStore:
@@ -220,8 +384,6 @@ func tokenBackendDescription(store storage.TokenStore) string {
 		default:
 			return "split (access: memory)"
 		}
-	case *storage.EventStore:
-		return tokenBackendDescription(typed.Inner())
 	case *storage.KeyringStore:
 		return "keyring"
 	case *storage.PassStore:
@@ -235,6 +397,20 @@ func tokenBackendDescription(store storage.TokenStore) string {
 	}
 }
 
+// filterDump keeps the tokens of account and its identities in a token dump.
+func filterDump(data []byte, account string) ([]byte, error) {
+	var dump map[string]json.RawMessage
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, err
+	}
+	for key := range dump {
+		if !config.KeyBelongsTo(key, account) {
+			delete(dump, key)
+		}
+	}
+	return json.Marshal(dump)
+}
+
 func readPayload(scanner *bufio.Scanner) ([]byte, error) {
 	var buf strings.Builder
 	for scanner.Scan() {
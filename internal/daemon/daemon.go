@@ -6,7 +6,8 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
@@ -18,9 +19,14 @@ import (
 	"time"
 
 	"github.com/vybraan/vygrant/internal/api"
+	"github.com/vybraan/vygrant/internal/audit"
 	"github.com/vybraan/vygrant/internal/auth"
 	"github.com/vybraan/vygrant/internal/certgen"
 	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/jwt"
+	"github.com/vybraan/vygrant/internal/logging"
+	"github.com/vybraan/vygrant/internal/notify"
+	"github.com/vybraan/vygrant/internal/render"
 	"github.com/vybraan/vygrant/internal/storage"
 )
 
@@ -34,6 +40,10 @@ type Daemon struct {
 	PublicKey       string
 	HTTPClient      *http.Client
 	LegacyMigration string
+
+	logCloser io.Closer
+	// proxySecret authenticates clients of the proxies; it changes every start.
+	proxySecret string
 }
 
 // NewDaemon creates a Daemon by loading configuration and initializing token storage.
@@ -60,22 +70,42 @@ func newSplitWithRefresh(refresh storage.TokenStore) *storage.SplitStore {
 	return storage.NewSplitStore(refresh)
 }
 
-func NewDaemon() (*Daemon, error) {
+// ConfigPath returns the configuration file path: $VYGRANT_CONFIG when set, otherwise
+// ~/.config/vybr/vygrant.toml.
+func ConfigPath() string {
 	confPath := os.Getenv("VYGRANT_CONFIG")
 	if confPath == "" {
 		home, _ := os.UserHomeDir()
 		confPath = path.Join(home, VYGRANT_CONFIG)
 	}
+	return confPath
+}
 
-	cfg, err := config.LoadConfig(confPath)
+// LoadConfig loads the configuration from ConfigPath and validates it.
+func LoadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(ConfigPath())
 	if err != nil {
 		return nil, err
 	}
+	applyKeyFileDefaults(cfg)
 	if err := validateConfig(cfg); err != nil {
 		return nil, err
 	}
+	return cfg, nil
+}
+
+func NewDaemon() (*Daemon, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	logCloser, err := logging.Setup(cfg.Log)
+	if err != nil {
+		return nil, err
+	}
 
 	auth.LoadedAccounts = cfg.Accounts
+	auth.LoadedHTTP = cfg.HTTP
 
 	var store storage.TokenStore
 	var legacyMigrated string
@@ -92,52 +122,115 @@ func NewDaemon() (*Daemon, error) {
 		Config:          cfg,
 		TokenStore:      store,
 		LegacyMigration: legacyMigrated,
+		logCloser:       logCloser,
 	}, nil
 }
 
 func initPersistentStore(legacyTokenPath string) (storage.TokenStore, string) {
 	refresh, backend := newRefreshStore()
 	if refresh != nil {
-		splitStore := newSplitWithRefresh(refresh)
+		splitStore := newSplitWithRefresh(storage.NewInstrumentedStore(refresh, backend, recordStorageError))
 		if migrated, backupPath, err := migrateLegacyTokens(legacyTokenPath, splitStore); err != nil {
-			log.Printf("warning: failed to migrate legacy tokens: %v", err)
+			slog.Warn("failed to migrate legacy tokens", "error", err)
 		} else if migrated {
 			msg := fmt.Sprintf("migrated legacy file to %s (backup: %s)", backend, backupPath)
-			log.Printf("legacy migration: %s", msg)
+			slog.Info("legacy migration", "backend", backend, "backup", backupPath)
 			return splitStore, msg
 		}
 		return splitStore, ""
 	}
 
 	if fileExists(legacyTokenPath) {
-		log.Println("warning: keyring unavailable; using legacy file token store")
-		return storage.NewFileStore(legacyTokenPath), ""
+		slog.Warn("keyring unavailable; using legacy file token store", "backend", "file")
+		return storage.NewInstrumentedStore(storage.NewFileStore(legacyTokenPath), "file", recordStorageError), ""
 	}
 
-	log.Println("warning: token persistence unavailable; falling back to in-memory token store")
+	slog.Warn("token persistence unavailable; falling back to in-memory token store", "backend", "memory")
 	return storage.NewMemoryStore(), ""
 }
 
+// authEvent is the auth.EventHook of the daemon. A completed authorization replaces
+// the account's tokens, so its refresh history starts over. Requests waiting for the
+// login get its outcome.
+func authEvent(account, event string, err error) {
+	if event == "auth_completed" && err == nil {
+		refreshStates.reset(account)
+		forgetNotifications(account)
+	}
+	if event == "auth_failed" && err == nil {
+		err = errors.New("authorization failed")
+	}
+	if event == "auth_completed" || event == "auth_failed" {
+		logins.complete(account, err)
+	}
+	auditAuthEvent(account, event, err)
+	publishAuth(account, event, err)
+}
+
 func (d *Daemon) Start() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	// reloadConfig may replace logCloser.
+	defer func() { d.logCloser.Close() }()
 
 	stopCh := make(chan struct{})
 
 	httpClient := &http.Client{}
 	d.HTTPClient = httpClient
 
+	if d.Config.AuditLog != "" {
+		opened, err := audit.Open(d.Config.AuditLog)
+		if err != nil {
+			fatal("audit log setup failed", "path", d.Config.AuditLog, "error", err)
+		}
+		auditLog = opened
+		defer opened.Close()
+	}
+	auth.EventHook = authEvent
+
+	var notifier notify.Notifier = notify.Desktop{}
+	if configured, err := notify.New(d.Config.Notify, d.Config.Browser); err != nil {
+		slog.Warn("notifier setup failed; using desktop notifications", "backend", d.Config.Notify.Backend, "error", err)
+	} else {
+		notifier = configured
+	}
+	setNotifier(notifier, d.authURL)
+
+	observed := storage.NewObservedStore(d.TokenStore)
+	d.TokenStore = observed
+	observed.Observe(observeTokenExpiry(observed))
+	observed.Observe(publishStoreChanges(observed))
+	observed.Observe(dropScopeTokens(d.Config, observed))
+	observed.Observe(dropExchangedTokens(d.Config, observed))
+	defer events.close()
 	if d.Config.TokenEventCmd != "" {
-		d.TokenStore = storage.NewEventStore(d.TokenStore, d.Config.TokenEventCmd)
+		observeChange, runner := tokenEventCmd(d.Config.TokenEventCmd)
+		defer runner.Close()
+		observed.Observe(observeChange)
+	}
+	if len(d.Config.Hooks) > 0 {
+		subscription, _ := events.subscribe()
+		go d.runHooks(subscription)
+	}
+
+	if len(d.Config.Templates) > 0 {
+		renderer, err := render.New(d.Config.Templates, d.templateToken)
+		if err != nil {
+			fatal("template setup failed", "error", err)
+		}
+		observed.Observe(renderer.Changed)
+		go renderer.RenderAll()
 	}
 
+	sched := newScheduler(d.Config, d.TokenStore, d.HTTPClient)
+	observed.Observe(sched.Changed)
 	bgWg.Add(1)
-	go StartBackgroundTasks(d.Config, d.TokenStore, d.HTTPClient, stopCh)
+	go sched.Run(stopCh)
 
-	httpsEnabled := isListenerEnabled(d.Config.HTTPSListen)
-	httpEnabled := isListenerEnabled(d.Config.HTTPListen)
+	httpsEnabled := IsListenerEnabled(d.Config.HTTPSListen)
+	httpEnabled := IsListenerEnabled(d.Config.HTTPListen)
 	if !httpsEnabled && !httpEnabled {
-		log.Fatal("no HTTP or HTTPS listener configured")
+		fatal("no HTTP or HTTPS listener configured")
 	}
 
 	var cert tls.Certificate
@@ -146,7 +239,7 @@ func (d *Daemon) Start() {
 		var err error
 		cert, publicKey, err = certgen.GenerateSelfSignedCert()
 		if err != nil {
-			log.Fatalf("tls setup failed: %v", err)
+			fatal("tls setup failed", "error", err)
 		}
 		d.PublicKey = publicKey
 	}
@@ -163,7 +256,7 @@ func (d *Daemon) Start() {
 	if httpEnabled {
 		httpListener, err = net.Listen("tcp", httpAddr)
 		if err != nil {
-			log.Fatalf("http listener failed: %v", err)
+			fatal("http listener failed", "error", err)
 		}
 	}
 
@@ -173,7 +266,7 @@ func (d *Daemon) Start() {
 			if httpListener != nil {
 				httpListener.Close()
 			}
-			log.Fatalf("https listener failed: %v", err)
+			fatal("https listener failed", "error", err)
 		}
 	}
 
@@ -185,7 +278,7 @@ func (d *Daemon) Start() {
 		if httpsListener != nil {
 			httpsListener.Close()
 		}
-		log.Fatal(err)
+		fatal(err.Error())
 	}
 
 	socketListener, err := net.Listen("unix", socketPath)
@@ -196,7 +289,7 @@ func (d *Daemon) Start() {
 		if httpsListener != nil {
 			httpsListener.Close()
 		}
-		log.Fatalf("socket listener failed: %v", err)
+		fatal("socket listener failed", "error", err)
 	}
 	defer func() {
 		socketListener.Close()
@@ -204,7 +297,26 @@ func (d *Daemon) Start() {
 	}()
 	go d.handleConnections(socketListener)
 
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 4+len(d.Config.Proxy.Reverse))
+
+	proxyServers, err := d.startProxies(errCh)
+	if err != nil {
+		if httpListener != nil {
+			httpListener.Close()
+		}
+		if httpsListener != nil {
+			httpsListener.Close()
+		}
+		fatal(err.Error())
+	}
+
+	var metricsServer *http.Server
+	if IsListenerEnabled(d.Config.MetricsListen) {
+		metricsServer, err = startMetricsServer(d.Config.MetricsListen, errCh)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
 
 	var httpServer *http.Server
 	if httpEnabled {
@@ -225,7 +337,7 @@ func (d *Daemon) Start() {
 	}
 
 	if httpsEnabled {
-		log.Println("oauth2 daemon is running")
+		slog.Info("oauth2 daemon is running", "http", d.Config.HTTPListen, "https", d.Config.HTTPSListen, "socket", socketPath)
 		tlsListener := tls.NewListener(httpsListener, httpsServer.TLSConfig)
 		go func() {
 			if err := httpsServer.Serve(tlsListener); err != nil && err != http.ErrServerClosed {
@@ -233,14 +345,22 @@ func (d *Daemon) Start() {
 			}
 		}()
 	} else {
-		log.Println("oauth2 daemon is running (http only)")
-	}
-
-	select {
-	case err := <-errCh:
-		log.Fatal(err)
-	case <-ctx.Done():
-		log.Println("shutting down daemon")
+		slog.Info("oauth2 daemon is running (http only)", "http", d.Config.HTTPListen, "socket", socketPath)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for running := true; running; {
+		select {
+		case err := <-errCh:
+			fatal(err.Error())
+		case <-hup:
+			d.reloadConfig()
+		case <-ctx.Done():
+			slog.Info("shutting down daemon")
+			running = false
+		}
 	}
 
 	close(stopCh)
@@ -251,12 +371,22 @@ func (d *Daemon) Start() {
 
 	if httpServer != nil {
 		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			log.Printf("http server shutdown: %v", err)
+			slog.Warn("http server shutdown", "error", err)
 		}
 	}
 	if httpsEnabled {
 		if err := httpsServer.Shutdown(shutdownCtx); err != nil {
-			log.Printf("https server shutdown: %v", err)
+			slog.Warn("https server shutdown", "error", err)
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("metrics server shutdown", "error", err)
+		}
+	}
+	for _, server := range proxyServers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("proxy shutdown", "error", err)
 		}
 	}
 }
@@ -272,7 +402,7 @@ func (d *Daemon) handleConnections(listener net.Listener) {
 			if errors.As(err, &netErr) && netErr.Timeout() {
 				continue
 			}
-			log.Printf("accept error: %v", err)
+			slog.Error("accept error", "error", err)
 			return
 		}
 		go d.handle(conn)
@@ -281,16 +411,44 @@ func (d *Daemon) handleConnections(listener net.Listener) {
 
 func (d *Daemon) handle(conn net.Conn) {
 	defer conn.Close()
+	var caller *audit.Caller
+	if auditLog != nil {
+		caller = audit.PeerCaller(conn)
+	}
 	scanner := bufio.NewScanner(conn)
 	for scanner.Scan() {
 		input := scanner.Text()
-		d.HandleCommand(conn, input, scanner)
+		fields := strings.Fields(input)
+		command := "unknown"
+		if len(fields) > 0 {
+			command = commandMetricLabel(fields[0])
+		}
+
+		start := time.Now()
+		if auditLog != nil && auditedCommands[command] {
+			recorder := &resultConn{Conn: conn}
+			d.HandleCommand(recorder, input, scanner)
+			recordAudit(recorder.entry(command, fields, caller))
+		} else {
+			d.HandleCommand(conn, input, scanner)
+		}
+
+		duration := time.Since(start)
+		socketCommands.Inc(command)
+		socketCommandDuration.Observe(duration.Seconds(), command)
+		slog.Debug("socket command", "command", command, "duration", duration)
 	}
 }
 
-// isListenerEnabled reports whether the given listener port string enables a listener.
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// IsListenerEnabled reports whether the given listener port string enables a listener.
 // It treats an empty string or the values "none", "off", and "disabled" (case-insensitive, with surrounding whitespace ignored) as disabled; all other values are considered enabled.
-func isListenerEnabled(port string) bool {
+func IsListenerEnabled(port string) bool {
 	trimmed := strings.TrimSpace(strings.ToLower(port))
 	return trimmed != "" && trimmed != "none" && trimmed != "off" && trimmed != "disabled"
 }
@@ -374,29 +532,107 @@ func validateConfig(cfg *config.Config) error {
 	if cfg == nil {
 		return fmt.Errorf("config is nil")
 	}
+	if err := validateProxyConfig(cfg); err != nil {
+		return err
+	}
+	if cfg.RefreshBefore < 0 || cfg.CheckInterval < 0 {
+		return fmt.Errorf("refresh_before and check_interval must not be negative")
+	}
+	for i, tmpl := range cfg.Templates {
+		if tmpl.Source == "" || tmpl.Destination == "" {
+			return fmt.Errorf("template %d is missing source or destination", i+1)
+		}
+	}
+	if err := validateHooks(cfg); err != nil {
+		return err
+	}
+	if err := validateNotify(cfg); err != nil {
+		return err
+	}
+	if cfg.HTTP.Timeout < 0 {
+		return fmt.Errorf("http timeout must not be negative")
+	}
+	if _, err := auth.HTTPClient(nil, cfg.HTTP); err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
 	if len(cfg.Accounts) == 0 {
 		return nil
 	}
 
-	httpsEnabled := isListenerEnabled(cfg.HTTPSListen)
-	httpEnabled := isListenerEnabled(cfg.HTTPListen)
+	httpsEnabled := IsListenerEnabled(cfg.HTTPSListen)
+	httpEnabled := IsListenerEnabled(cfg.HTTPListen)
 
 	for name, acct := range cfg.Accounts {
 		if acct == nil {
 			return fmt.Errorf("account %q is nil", name)
 		}
-		if acct.AuthURI == "" || acct.TokenURI == "" || acct.RedirectURI == "" || acct.ClientID == "" {
+		if strings.ContainsAny(name, config.IdentitySeparator+config.ScopeSeparator) {
+			return fmt.Errorf("account %q must not contain %q or %q", name, config.IdentitySeparator, config.ScopeSeparator)
+		}
+		for identity, ident := range acct.Identities {
+			if identity == "" || strings.ContainsAny(identity, config.IdentitySeparator+config.ScopeSeparator) || ident == nil {
+				return fmt.Errorf("account %q has an invalid identity %q", name, identity)
+			}
+		}
+		for set, scopes := range acct.Scopes.Sets {
+			if set == "" || strings.ContainsAny(set, config.IdentitySeparator+config.ScopeSeparator) {
+				return fmt.Errorf("account %q has an invalid scope set name %q", name, set)
+			}
+			if len(scopes.Scopes) == 0 {
+				return fmt.Errorf("account %q scope set %q has no scopes", name, set)
+			}
+		}
+		if acct.RefreshBefore < 0 || acct.CheckInterval < 0 {
+			return fmt.Errorf("account %q refresh_before and check_interval must not be negative", name)
+		}
+		if acct.TokenURI == "" && acct.GrantType() == config.GrantJWTBearer {
+			if err := validateJWTBearer(name, acct); err != nil {
+				return err
+			}
+			return fmt.Errorf("account %q has no token_uri and its key_file does not name one", name)
+		}
+		if acct.TokenURI == "" {
 			return fmt.Errorf("account %q is missing required fields", name)
 		}
-		if err := validateURL(acct.AuthURI, "auth_uri", name); err != nil {
+		if err := validateURL(acct.TokenURI, "token_uri", name); err != nil {
 			return err
 		}
-		if err := validateURL(acct.TokenURI, "token_uri", name); err != nil {
+		for _, resource := range acct.Resource {
+			if parsed, err := url.Parse(resource); err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+				return fmt.Errorf("account %q resource %q must be an absolute URI without a fragment", name, resource)
+			}
+		}
+		if err := validateParamFields(name, "token_uri_fields", acct.TokenURIFields, reservedTokenParams); err != nil {
+			return err
+		}
+		if err := validateClientAuth(name, acct); err != nil {
+			return err
+		}
+		if acct.HTTP.Timeout < 0 {
+			return fmt.Errorf("account %q http timeout must not be negative", name)
+		}
+		if _, err := auth.HTTPClient(nil, config.AccountHTTP(cfg.HTTP, acct)); err != nil {
+			return fmt.Errorf("account %q http: %w", name, err)
+		}
+		if acct.GrantType() != config.GrantAuthorizationCode {
+			if err := validateGrant(cfg, name, acct); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if acct.AuthURI == "" || acct.RedirectURI == "" || acct.ClientID == "" {
+			return fmt.Errorf("account %q is missing required fields", name)
+		}
+		if err := validateURL(acct.AuthURI, "auth_uri", name); err != nil {
 			return err
 		}
 		if err := validateURL(acct.RedirectURI, "redirect_uri", name); err != nil {
 			return err
 		}
+		if err := validateParamFields(name, "auth_uri_fields", acct.AuthURIFields, reservedAuthParams); err != nil {
+			return err
+		}
 
 		redirectURL, _ := url.Parse(acct.RedirectURI)
 		switch redirectURL.Scheme {
@@ -429,3 +665,54 @@ func validateURL(rawURL, field, account string) error {
 	}
 	return nil
 }
+
+// Parameters that vygrant sets itself, which auth_uri_fields and token_uri_fields
+// must not replace.
+var (
+	reservedAuthParams  = []string{"state", "client_id", "redirect_uri", "response_type"}
+	reservedTokenParams = []string{
+		"grant_type", "code", "refresh_token", "client_id", "redirect_uri", "code_verifier",
+		"client_secret", "client_assertion", "client_assertion_type", "assertion",
+	}
+)
+
+func validateParamFields(account, field string, fields map[string]string, reserved []string) error {
+	for _, name := range reserved {
+		if _, ok := fields[name]; ok {
+			return fmt.Errorf("account %q %s must not set %s", account, field, name)
+		}
+	}
+	return nil
+}
+
+// validateClientAuth checks token_endpoint_auth_method of an account and loads the
+// key or certificate it needs, so a missing file fails at startup rather than at
+// the first token request.
+func validateClientAuth(name string, acct *config.Account) error {
+	switch acct.TokenEndpointAuthMethod {
+	case "", config.AuthClientSecretBasic, config.AuthClientSecretPost:
+		return nil
+	case config.AuthPrivateKeyJWT:
+		if acct.ClientAssertionKey == "" {
+			return fmt.Errorf("account %q uses private_key_jwt but has no client_assertion_key", name)
+		}
+		if _, err := jwt.LoadKey(acct.ClientAssertionKey); err != nil {
+			return fmt.Errorf("account %q client_assertion_key: %w", name, err)
+		}
+		if acct.ClientAssertionAudience != "" {
+			if err := validateURL(acct.ClientAssertionAudience, "client_assertion_audience", name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case config.AuthTLSClient:
+		if acct.TLSClientCert == "" || acct.TLSClientKey == "" {
+			return fmt.Errorf("account %q uses tls_client_auth but is missing tls_client_cert or tls_client_key", name)
+		}
+		if _, err := tls.LoadX509KeyPair(acct.TLSClientCert, acct.TLSClientKey); err != nil {
+			return fmt.Errorf("account %q tls client certificate: %w", name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("account %q has an unknown token_endpoint_auth_method %q", name, acct.TokenEndpointAuthMethod)
+}
@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+func TestValidateConfigRejectsReservedParams(t *testing.T) {
+	tests := []struct {
+		auth, token map[string]string
+		wantErr     string
+	}{
+		{auth: map[string]string{"prompt": "consent"}, token: map[string]string{"tenant": "example"}},
+		{auth: map[string]string{"state": "x"}, wantErr: `account "work" auth_uri_fields must not set state`},
+		{auth: map[string]string{"client_id": "x"}, wantErr: `account "work" auth_uri_fields must not set client_id`},
+		{auth: map[string]string{"redirect_uri": "x"}, wantErr: `account "work" auth_uri_fields must not set redirect_uri`},
+		{auth: map[string]string{"response_type": "token"}, wantErr: `account "work" auth_uri_fields must not set response_type`},
+		{token: map[string]string{"grant_type": "password"}, wantErr: `account "work" token_uri_fields must not set grant_type`},
+		{token: map[string]string{"code": "x"}, wantErr: `account "work" token_uri_fields must not set code`},
+		{token: map[string]string{"refresh_token": "x"}, wantErr: `account "work" token_uri_fields must not set refresh_token`},
+		{token: map[string]string{"client_id": "x"}, wantErr: `account "work" token_uri_fields must not set client_id`},
+		{token: map[string]string{"redirect_uri": "x"}, wantErr: `account "work" token_uri_fields must not set redirect_uri`},
+		{token: map[string]string{"code_verifier": "x"}, wantErr: `account "work" token_uri_fields must not set code_verifier`},
+		{token: map[string]string{"client_secret": "x"}, wantErr: `account "work" token_uri_fields must not set client_secret`},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{
+			HTTPListen: "8080",
+			Accounts: map[string]*config.Account{"work": {
+				AuthURI:        "https://login.example.com/authorize",
+				TokenURI:       "https://login.example.com/token",
+				RedirectURI:    "http://localhost:8080/callback",
+				ClientID:       "app",
+				AuthURIFields:  tt.auth,
+				TokenURIFields: tt.token,
+			}},
+		}
+		err := validateConfig(cfg)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.wantErr {
+			t.Errorf("validateConfig(auth %v, token %v) = %q, want %q", tt.auth, tt.token, got, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/hooks"
+	"github.com/vybraan/vygrant/internal/storage"
+)
+
+// tokenEventCmd returns the storage.ChangeFunc that queues token_event_cmd for every
+// token change, with VYGRANT_EVENT set to "set", "delete" or "restore", and the
+// runner that executes it. The runs happen in order on the runner's worker, so a
+// slow command never holds up a token save. Close the runner to wait for them.
+func tokenEventCmd(command string) (storage.ChangeFunc, *hooks.Runner) {
+	runner := hooks.NewOrdered([]config.Hook{{Command: command}}, nil)
+	return func(account, event string) {
+		runner.Dispatch(hooks.Payload{Event: event, Account: account, Time: time.Now().UTC()})
+	}, runner
+}
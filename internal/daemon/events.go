@@ -0,0 +1,176 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+// Event types published on the event bus and streamed by the subscribe command.
+const (
+	EventTokenSet       = "token_set"
+	EventTokenDeleted   = "token_deleted"
+	EventRefreshOK      = "refresh_ok"
+	EventRefreshFailed  = "refresh_failed"
+	EventAuthStarted    = "auth_started"
+	EventAuthCompleted  = "auth_completed"
+	EventExpiringSoon   = "expiring_soon"
+	EventConfigReloaded = "config_reloaded"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind before further
+// events are dropped for it.
+const eventBufferSize = 256
+
+// Event is one daemon event. Account is "*" for events that concern every account,
+// such as a token restore. Kind is the refresh error class of refresh_failed.
+type Event struct {
+	Time    time.Time  `json:"time"`
+	Type    string     `json:"type"`
+	Account string     `json:"account,omitempty"`
+	Expiry  *time.Time `json:"expiry,omitempty"`
+	Kind    string     `json:"kind,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// eventBus fans events out to subscribers. Publishing never blocks: a subscriber
+// whose buffer is full misses the event.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+var events = &eventBus{subs: map[chan Event]struct{}{}}
+
+// subscribe returns a channel of future events and a function that cancels the
+// subscription. The channel is closed on cancel or when the bus is closed.
+func (b *eventBus) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBus) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			slog.Warn("event subscriber is too slow; dropping event", "event", e.Type, "account", e.Account)
+		}
+	}
+}
+
+// close ends every subscription.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// streamEvents writes events as newline-delimited JSON to conn until the client
+// disconnects or the daemon shuts down. When account is set only its events, those
+// of its identities and those for every account ("*" or none) are sent.
+func streamEvents(conn net.Conn, scanner *bufio.Scanner, account string) {
+	ch, cancel := events.subscribe()
+	defer cancel()
+
+	disconnected := watchDisconnect(scanner)
+	defer func() {
+		conn.Close()
+		<-disconnected
+	}()
+
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if account != "" && e.Account != "" && e.Account != "*" && !config.KeyBelongsTo(e.Account, account) {
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		}
+	}
+}
+
+// publishStoreChanges is the storage.ChangeFunc that turns token store changes into
+// token_set and token_deleted events.
+func publishStoreChanges(store storage.TokenStore) storage.ChangeFunc {
+	return func(account, event string) {
+		switch event {
+		case "set", "restore":
+			e := Event{Type: EventTokenSet, Account: account}
+			if token, err := store.Get(account); err == nil && !token.Expiry.IsZero() {
+				expiry := token.Expiry
+				e.Expiry = &expiry
+			}
+			events.publish(e)
+		case "delete":
+			events.publish(Event{Type: EventTokenDeleted, Account: account})
+		}
+	}
+}
+
+// publishRefresh publishes the outcome of a refresh of account.
+func publishRefresh(account string, token *oauth2.Token, err error) {
+	if err != nil {
+		events.publish(Event{Type: EventRefreshFailed, Account: account, Kind: string(refreshErrorKindOf(err)), Error: strings.TrimSpace(err.Error())})
+		return
+	}
+	e := Event{Type: EventRefreshOK, Account: account}
+	if !token.Expiry.IsZero() {
+		expiry := token.Expiry
+		e.Expiry = &expiry
+	}
+	events.publish(e)
+}
+
+// publishAuth is called from authEvent for authorization flow events. A failed
+// callback is reported as auth_completed with Error set.
+func publishAuth(account, event string, err error) {
+	e := Event{Type: EventAuthCompleted, Account: account}
+	if event == "auth_started" {
+		e.Type = EventAuthStarted
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	events.publish(e)
+}
@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func TestEventBusDropsEventsForFullSubscribers(t *testing.T) {
+	bus := &eventBus{subs: map[chan Event]struct{}{}}
+	slow, _ := bus.subscribe()
+	for i := 0; i < eventBufferSize+10; i++ {
+		bus.publish(Event{Type: EventRefreshOK, Account: "work"})
+	}
+	if len(slow) != eventBufferSize {
+		t.Errorf("buffered events = %d, want %d", len(slow), eventBufferSize)
+	}
+
+	other, cancel := bus.subscribe()
+	bus.publish(Event{Type: EventTokenDeleted, Account: "work"})
+	if e := <-other; e.Type != EventTokenDeleted || e.Time.IsZero() {
+		t.Errorf("event = %+v, want a timestamped token_deleted", e)
+	}
+	cancel()
+	cancel()
+	if _, ok := <-other; ok {
+		t.Error("cancelled subscription is still open")
+	}
+
+	bus.close()
+	for range slow {
+	}
+	late, _ := bus.subscribe()
+	if _, ok := <-late; ok {
+		t.Error("subscription after close is open")
+	}
+}
+
+func TestSubscribeStreamsEventsOfAccount(t *testing.T) {
+	d := &Daemon{Config: &config.Config{Accounts: map[string]*config.Account{
+		"work":  {Identities: map[string]*config.Identity{"alice": {}}},
+		"works": {},
+	}}}
+	conn := dialDaemon(t, d)
+
+	if _, err := conn.Write([]byte("subscribe work\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscribers(t, 1)
+
+	events.publish(Event{Type: EventRefreshOK, Account: "works"})
+	events.publish(Event{Type: EventRefreshOK, Account: "work"})
+	events.publish(Event{Type: EventRefreshFailed, Account: "work/alice", Kind: "transient", Error: "503"})
+	events.publish(Event{Type: EventTokenSet, Account: "*"})
+
+	scanner := bufio.NewScanner(conn)
+	var got []string
+	for len(got) < 3 && scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid event line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, e.Type+" "+e.Account)
+	}
+	want := []string{"refresh_ok work", "refresh_failed work/alice", "token_set *"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+
+	// The daemon ends the stream when the client closes its write side.
+	conn.(*net.UnixConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("stream did not end after disconnect: %v", err)
+	}
+	waitForSubscribers(t, 0)
+}
+
+func TestSubscribeRejectsUnknownAccount(t *testing.T) {
+	d := &Daemon{Config: &config.Config{Accounts: map[string]*config.Account{"work": {}}}}
+	conn := dialDaemon(t, d)
+	conn.Write([]byte("subscribe other\n"))
+	conn.(*net.UnixConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	out, _ := io.ReadAll(conn)
+	if !strings.HasPrefix(string(out), "ERROR: Could not subscribe to 'other'") {
+		t.Errorf("response = %q", out)
+	}
+}
+
+func TestPublishStoreChanges(t *testing.T) {
+	ch, cancel := events.subscribe()
+	defer cancel()
+	observed := storage.NewObservedStore(storage.NewMemoryStore())
+	observed.Observe(publishStoreChanges(observed))
+
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	observed.Set("work", &oauth2.Token{AccessToken: "a", Expiry: expiry})
+	observed.Delete("work")
+
+	if e := <-ch; e.Type != EventTokenSet || e.Account != "work" || e.Expiry == nil || !e.Expiry.Equal(expiry) {
+		t.Errorf("first event = %+v, want token_set with expiry", e)
+	}
+	if e := <-ch; e.Type != EventTokenDeleted || e.Account != "work" {
+		t.Errorf("second event = %+v, want token_deleted", e)
+	}
+}
+
+func TestTokenEventCmdRunsOnStoreChanges(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	observed := storage.NewObservedStore(storage.NewMemoryStore())
+	release := filepath.Join(t.TempDir(), "release")
+	// The command waits for release, so the store changes only return first when
+	// they do not wait for it.
+	observeChange, runner := tokenEventCmd(`while [ ! -e ` + release + ` ]; do sleep 0.01; done; echo "$VYGRANT_ACCOUNT $VYGRANT_EVENT" >> ` + out)
+	observed.Observe(observeChange)
+
+	observed.Set("work", &oauth2.Token{AccessToken: "a"})
+	observed.Delete("work")
+	dump, err := observed.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := observed.Restore(dump); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("token_event_cmd ran before it was released: %v", err)
+	}
+	if err := os.WriteFile(release, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	runner.Close()
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "work set\nwork delete\n* restore\n"
+	if string(data) != want {
+		t.Errorf("token_event_cmd runs = %q, want %q", data, want)
+	}
+}
+
+// dialDaemon serves d on a Unix socket and returns a connection to it.
+func dialDaemon(t *testing.T, d *Daemon) net.Conn {
+	t.Helper()
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go d.handleConnections(listener)
+	conn, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitForSubscribers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events.mu.Lock()
+		count := len(events.subs)
+		events.mu.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscribers = %d, want %d", count, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,170 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// tokenTypePrefix prefixes the short token type names of RFC 8693, such as
+	// access_token or jwt.
+	tokenTypePrefix = "urn:ietf:params:oauth:token-type:"
+)
+
+// ExchangeToken exchanges the access token subject of the subject account for a token
+// of the token exchange account (RFC 8693), sending its audience, resource and
+// requested_token_type.
+func ExchangeToken(account string, cfg *config.Config, subject *oauth2.Token, httpClient *http.Client) (*oauth2.Token, error) {
+	acct, ok := cfg.LookupAccount(account)
+	if !ok || acct == nil {
+		return nil, ErrAccountNotFound
+	}
+	params := config.TokenParams(acct)
+	params.Set("grant_type", tokenExchangeGrant)
+	params.Set("subject_token", subject.AccessToken)
+	params.Set("subject_token_type", tokenTypePrefix+"access_token")
+	if acct.RequestedTokenType != "" {
+		params.Set("requested_token_type", tokenType(acct.RequestedTokenType))
+	}
+	return requestGrant(account, cfg, acct, params, httpClient)
+}
+
+// tokenType returns the token type URI for name, which is either a URI or one of
+// the short names of RFC 8693.
+func tokenType(name string) string {
+	if strings.Contains(name, ":") {
+		return name
+	}
+	return tokenTypePrefix + name
+}
+
+// exchangedToken returns an access token for the token exchange account. It is
+// cached in the token store and exchanged again from the current token of the
+// subject account when it is missing, has expired or force is set; dropExchangedTokens
+// removes it whenever the subject's token changes.
+func (d *Daemon) exchangedToken(account string, force bool) (*oauth2.Token, error) {
+	cachedToken := func() *oauth2.Token {
+		if cached, err := d.TokenStore.Get(account); err == nil && cached.Valid() {
+			return cached
+		}
+		return nil
+	}
+	if cached := cachedToken(); cached != nil && !force {
+		return cached, nil
+	}
+
+	acct, ok := d.Config.LookupAccount(account)
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return refreshFlights.do(account, func() (*oauth2.Token, error) {
+		if cached := cachedToken(); cached != nil && !force {
+			return cached, nil
+		}
+		subject, err := d.accessToken(acct.SubjectAccount, false)
+		if err != nil {
+			return nil, fmt.Errorf("subject account '%s': %w", acct.SubjectAccount, err)
+		}
+
+		newToken, err := withRetry(account, func() (*oauth2.Token, error) {
+			return ExchangeToken(account, d.Config, subject, d.HTTPClient)
+		})
+		refreshStates.record(account, err)
+		publishRefresh(account, newToken, err)
+		if err != nil {
+			if isRefreshRejected(err) {
+				if err := d.TokenStore.Delete(account); err != nil && !errors.Is(err, os.ErrNotExist) {
+					slog.Error("failed to delete rejected exchanged token", "account", account, "error", err)
+				}
+			}
+			return nil, err
+		}
+
+		// The token is exchanged again rather than refreshed, so a refresh token
+		// issued with it is not kept.
+		cached := &oauth2.Token{AccessToken: newToken.AccessToken, TokenType: newToken.TokenType, Expiry: newToken.Expiry}
+		if err := d.TokenStore.Set(account, cached); err != nil {
+			slog.Error("failed to save exchanged token", "account", account, "error", err)
+		}
+		return cached, nil
+	})
+}
+
+// isExchanged reports whether key is a token exchange account.
+func isExchanged(cfg *config.Config, key string) bool {
+	acct, ok := cfg.LookupAccount(key)
+	return ok && acct.GrantType() == config.GrantTokenExchange
+}
+
+// loginAccount returns the key whose authorization produces the tokens of key: the
+// account of a scope set, and the root subject of a token exchange account.
+func (d *Daemon) loginAccount(key string) string {
+	key, _ = config.SplitScopeKey(key)
+	for range len(d.Config.Accounts) {
+		acct, ok := d.Config.LookupAccount(key)
+		if !ok || acct.GrantType() != config.GrantTokenExchange {
+			break
+		}
+		key, _ = config.SplitScopeKey(acct.SubjectAccount)
+	}
+	return key
+}
+
+// hasLogin reports whether the tokens of key come from an account that logs in with
+// the browser, rather than from a jwt_bearer account.
+func (d *Daemon) hasLogin(key string) bool {
+	acct, ok := d.Config.LookupAccount(d.loginAccount(key))
+	return ok && acct.GrantType() == config.GrantAuthorizationCode
+}
+
+// dropExchangedTokens is the storage.ChangeFunc that deletes the cached tokens of the
+// token exchange accounts whose subject token changed, so the next request
+// exchanges the new one. Deleting a token continues down a chain of exchanges.
+func dropExchangedTokens(cfg *config.Config, store storage.TokenStore) storage.ChangeFunc {
+	return func(account, event string) {
+		if account == "*" {
+			return
+		}
+		for name, acct := range cfg.Accounts {
+			if acct.GrantType() != config.GrantTokenExchange || acct.SubjectAccount != account {
+				continue
+			}
+			if err := store.Delete(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("failed to delete exchanged token", "account", name, "error", err)
+			}
+		}
+	}
+}
+
+// validateTokenExchange checks that the subject account of a token exchange account
+// exists and that the chain of subject accounts ends at one that is not exchanged.
+func validateTokenExchange(cfg *config.Config, name string, acct *config.Account) error {
+	if acct.SubjectAccount == "" {
+		return fmt.Errorf("account %q uses token_exchange but has no subject_account", name)
+	}
+	seen := map[string]bool{name: true}
+	for subject := acct.SubjectAccount; ; {
+		next, ok := cfg.LookupAccount(subject)
+		if !ok {
+			return fmt.Errorf("account %q subject_account %q is not configured", name, subject)
+		}
+		if next.GrantType() != config.GrantTokenExchange {
+			return nil
+		}
+		base, _ := config.SplitScopeKey(subject)
+		if seen[base] {
+			return fmt.Errorf("account %q subject_account chain loops at %q", name, subject)
+		}
+		seen[base] = true
+		subject = next.SubjectAccount
+	}
+}
@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func TestExchangedTokenFollowsSubjectToken(t *testing.T) {
+	var requests atomic.Int32
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		requests.Add(1)
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" ||
+			r.Form.Get("subject_token_type") != "urn:ietf:params:oauth:token-type:access_token" ||
+			r.Form.Get("requested_token_type") != "urn:ietf:params:oauth:token-type:jwt" ||
+			r.Form.Get("audience") != "downstream" {
+			t.Errorf("unexpected exchange request: %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":      "exchanged-" + r.Form.Get("subject_token"),
+			"issued_token_type": "urn:ietf:params:oauth:token-type:jwt",
+			"token_type":        "Bearer",
+			"expires_in":        3600,
+		})
+	}))
+	defer tokenEndpoint.Close()
+
+	cfg := &config.Config{
+		Accounts: map[string]*config.Account{
+			"primary": {TokenURI: tokenEndpoint.URL},
+			"downstream": {
+				TokenURI: tokenEndpoint.URL, ClientID: "client",
+				Grant: config.GrantTokenExchange, SubjectAccount: "primary",
+				Audience: []string{"downstream"}, RequestedTokenType: "jwt",
+			},
+		},
+	}
+	store := storage.NewObservedStore(storage.NewMemoryStore())
+	store.Observe(dropExchangedTokens(cfg, store))
+	d := &Daemon{Config: cfg, TokenStore: store, HTTPClient: tokenEndpoint.Client()}
+
+	if err := store.Set("primary", &oauth2.Token{AccessToken: "first", Expiry: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		token, err := d.accessToken("downstream", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "exchanged-first" {
+			t.Fatalf("access token = %q, want exchanged-first", token.AccessToken)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("token endpoint called %d times, want 1", got)
+	}
+
+	if err := store.Set("primary", &oauth2.Token{AccessToken: "second", Expiry: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := d.accessToken("downstream", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "exchanged-second" {
+		t.Fatalf("access token after the subject changed = %q, want exchanged-second", token.AccessToken)
+	}
+}
+
+func TestValidateConfigRejectsScopeFieldWithScopes(t *testing.T) {
+	tests := []struct {
+		fields  map[string]string
+		scopes  []string
+		wantErr string
+	}{
+		{fields: map[string]string{"scope": "read"}},
+		{scopes: []string{"read"}},
+		{fields: map[string]string{"scope": "read"}, scopes: []string{"write"},
+			wantErr: `account "reports" with grant "token_exchange" sets scope in token_uri_fields and scopes; use scopes only`},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{
+			HTTPListen: "8080",
+			Accounts: map[string]*config.Account{
+				"work": {
+					AuthURI:     "https://login.example.com/authorize",
+					TokenURI:    "https://login.example.com/token",
+					RedirectURI: "http://localhost:8080/callback",
+					ClientID:    "app",
+				},
+				"reports": {
+					Grant: config.GrantTokenExchange, SubjectAccount: "work",
+					TokenURI: "https://sts.example.com/token", TokenURIFields: tt.fields,
+					Scopes: config.Scopes{List: tt.scopes},
+				},
+			},
+		}
+		got := ""
+		if err := validateConfig(cfg); err != nil {
+			got = err.Error()
+		}
+		if got != tt.wantErr {
+			t.Errorf("validateConfig(fields %v, scopes %v) = %q, want %q", tt.fields, tt.scopes, got, tt.wantErr)
+		}
+	}
+}
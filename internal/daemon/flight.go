@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshCall is a refresh in progress; waiters block on done and share its result.
+type refreshCall struct {
+	done  chan struct{}
+	token *oauth2.Token
+	err   error
+}
+
+// refreshGroup runs at most one refresh per account at a time. Callers that arrive
+// while a refresh is running wait for it instead of starting their own, so
+// providers that rotate refresh tokens never see the same refresh token twice.
+type refreshGroup struct {
+	mu    sync.Mutex
+	calls map[string]*refreshCall
+}
+
+var refreshFlights = &refreshGroup{calls: map[string]*refreshCall{}}
+
+// refreshTokenLocks serializes the requests that spend the refresh token of an
+// account: its own refreshes and those of its scope sets. They have separate flights
+// but must not send the same refresh token at once.
+var refreshTokenLocks = &accountLocks{locks: map[string]*sync.Mutex{}}
+
+// accountLocks holds one mutex per account.
+type accountLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock locks the mutex of account and returns the function that unlocks it.
+func (l *accountLocks) lock(account string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[account]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[account] = lock
+	}
+	l.mu.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// do runs fn for account unless a call for account is already running, in which case
+// it waits for that call and returns its result.
+func (g *refreshGroup) do(account string, fn func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[account]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	g.calls[account] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, account)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.token, call.err = fn()
+	return call.token, call.err
+}
@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func TestConcurrentRefreshesShareOneRequest(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  fmt.Sprintf("new-access-%d", n),
+			"refresh_token": fmt.Sprintf("rotated-%d", n),
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	defer tokenEndpoint.Close()
+
+	expired := &oauth2.Token{
+		AccessToken:  "old-access",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Minute),
+	}
+	store := storage.NewMemoryStore()
+	if err := store.Set("acct", expired); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Accounts: map[string]*config.Account{
+			"acct": {TokenURI: tokenEndpoint.URL},
+		},
+	}
+
+	const callers = 8
+	var wg sync.WaitGroup
+	results := make(chan string, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := refreshAccount("acct", cfg, store, tokenEndpoint.Client(), expired)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results <- token.AccessToken
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		checkExpiringTokens(cfg, store, tokenEndpoint.Client())
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("token endpoint received %d requests, want 1", got)
+	}
+	for access := range results {
+		if access != "new-access-1" {
+			t.Fatalf("caller got %q, want new-access-1", access)
+		}
+	}
+}
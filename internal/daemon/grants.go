@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/auth"
+	"github.com/vybraan/vygrant/internal/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// requestGrant requests a token for account from its token endpoint with the grant
+// and parameters in params, authenticating the client like a refresh does. The
+// account's scopes are sent when it has any.
+func requestGrant(account string, cfg *config.Config, acct *config.Account, params url.Values, httpClient *http.Client) (*oauth2.Token, error) {
+	oauthCfg := config.GetOAuth2Config(acct)
+	grant := clientcredentials.Config{
+		ClientID:       oauthCfg.ClientID,
+		ClientSecret:   oauthCfg.ClientSecret,
+		TokenURL:       oauthCfg.Endpoint.TokenURL,
+		Scopes:         oauthCfg.Scopes,
+		EndpointParams: params,
+		AuthStyle:      oauthCfg.Endpoint.AuthStyle,
+	}
+	if grant.ClientID == "" {
+		// Without a client there are no credentials to detect a style for.
+		grant.AuthStyle = oauth2.AuthStyleInParams
+	}
+
+	httpClient, err := auth.TokenClient(acct, config.AccountHTTP(cfg.HTTP, acct), httpClient, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	start := time.Now()
+	token, err := grant.Token(ctx)
+	recordRefresh(account, err)
+	slog.Debug("token grant request", "account", account, "grant", acct.GrantType(), "duration", time.Since(start), "ok", err == nil)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// validateGrant checks an account whose grant is not authorization_code. Such an
+// account has no login, so it cannot have identities or scope sets. requestGrant
+// sends its scopes as the scope parameter, which token_uri_fields cannot set again.
+func validateGrant(cfg *config.Config, name string, acct *config.Account) error {
+	if len(acct.Identities) > 0 || len(acct.Scopes.Sets) > 0 {
+		return fmt.Errorf("account %q with grant %q cannot have identities or scope sets", name, acct.Grant)
+	}
+	if _, ok := acct.TokenURIFields["scope"]; ok && len(acct.Scopes.List) > 0 {
+		return fmt.Errorf("account %q with grant %q sets scope in token_uri_fields and scopes; use scopes only", name, acct.Grant)
+	}
+	switch acct.Grant {
+	case config.GrantTokenExchange:
+		return validateTokenExchange(cfg, name, acct)
+	case config.GrantJWTBearer:
+		return validateJWTBearer(name, acct)
+	}
+	return fmt.Errorf("account %q has an unknown grant %q", name, acct.Grant)
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package daemon
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchHangup returns a channel that is closed once the client on conn closes its
+// end of the connection, and a function that stops watching. Clients half-close
+// after sending a command, so reading cannot tell a hangup from the end of the
+// request; the kernel reports POLLHUP only once both directions are shut down.
+// POLLRDHUP is not asked for, since the half-close alone raises it.
+func watchHangup(conn net.Conn) (<-chan struct{}, func()) {
+	unixConn := baseUnixConn(conn)
+	if unixConn == nil {
+		return nil, func() {}
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, func() {}
+	}
+	// Closing the write end of wake ends the poll when watching stops.
+	var wake [2]int
+	if err := unix.Pipe2(wake[:], unix.O_CLOEXEC); err != nil {
+		return nil, func() {}
+	}
+
+	hangup := make(chan struct{})
+	go func() {
+		defer unix.Close(wake[0])
+		hup := false
+		if err := raw.Control(func(fd uintptr) {
+			fds := []unix.PollFd{{Fd: int32(fd)}, {Fd: int32(wake[0]), Events: unix.POLLIN}}
+			for {
+				if _, err := unix.Poll(fds, -1); err == unix.EINTR {
+					continue
+				} else if err != nil {
+					return
+				}
+				// Once watching stopped, a hangup is no longer reported.
+				hup = fds[1].Revents == 0 && fds[0].Revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0
+				return
+			}
+		}); err != nil {
+			hup = true
+		}
+		if hup {
+			close(hangup)
+		}
+	}()
+	var once sync.Once
+	return hangup, func() { once.Do(func() { unix.Close(wake[1]) }) }
+}
+
+// baseUnixConn returns the Unix socket connection under conn, or nil.
+func baseUnixConn(conn net.Conn) *net.UnixConn {
+	for {
+		switch c := conn.(type) {
+		case *net.UnixConn:
+			return c
+		case *resultConn:
+			conn = c.Conn
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchHangupIgnoresHalfClose(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	hangup, stop := watchHangup(&resultConn{Conn: server})
+	defer stop()
+
+	// A client half-closes after sending its command and keeps reading.
+	client.Write([]byte("get-token work\n"))
+	client.(*net.UnixConn).CloseWrite()
+	select {
+	case <-hangup:
+		t.Fatal("half-closed connection reported as hung up")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	client.Close()
+	select {
+	case <-hangup:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closed connection not reported as hung up")
+	}
+}
+
+func TestWatchHangupStop(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if hangup, stop := watchHangup(server); hangup != nil {
+		stop()
+		t.Error("watching a connection that is not a Unix socket")
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+
+	hangup, stop := watchHangup(accepted)
+	stop()
+	stop()
+	conn.Close()
+	select {
+	case <-hangup:
+		t.Fatal("hangup reported after watching stopped")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
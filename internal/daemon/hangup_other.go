@@ -0,0 +1,10 @@
+//go:build !linux
+
+package daemon
+
+import "net"
+
+// watchHangup is not supported on this platform; waits end at their timeout.
+func watchHangup(conn net.Conn) (<-chan struct{}, func()) {
+	return nil, func() {}
+}
@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/hooks"
+)
+
+// runHooks feeds the events of subscription to the [[hook]] commands until the event
+// bus is closed, then waits for the queued hook runs.
+func (d *Daemon) runHooks(subscription <-chan Event) {
+	runner := hooks.New(d.Config.Hooks, d.storedAccessToken)
+	defer runner.Close()
+	for e := range subscription {
+		runner.Dispatch(d.hookPayload(e))
+	}
+}
+
+// hookPayload adds the account's scopes and token backend to e.
+func (d *Daemon) hookPayload(e Event) hooks.Payload {
+	p := hooks.Payload{
+		Event:   e.Type,
+		Account: e.Account,
+		Time:    e.Time,
+		Expiry:  e.Expiry,
+		Reason:  e.Error,
+		Kind:    e.Kind,
+	}
+	if acct, ok := d.Config.LookupAccount(e.Account); ok {
+		_, set := config.SplitScopeKey(e.Account)
+		p.Scopes = acct.Scopes.For(set)
+		p.Backend = refreshBackend(d.TokenStore)
+	}
+	return p
+}
+
+// storedAccessToken returns the access token currently stored for account without
+// refreshing it, so a hook never triggers a refresh.
+func (d *Daemon) storedAccessToken(account string) (string, error) {
+	token, err := d.TokenStore.Get(account)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+var hookEvents = []string{
+	EventTokenSet, EventTokenDeleted, EventRefreshOK, EventRefreshFailed,
+	EventAuthStarted, EventAuthCompleted, EventExpiringSoon, EventConfigReloaded,
+}
+
+func validateHooks(cfg *config.Config) error {
+	for i, hook := range cfg.Hooks {
+		if hook.Command == "" {
+			return fmt.Errorf("hook %d is missing command", i+1)
+		}
+		if hook.Retries < 0 || hook.Timeout < 0 {
+			return fmt.Errorf("hook %d retries and timeout must not be negative", i+1)
+		}
+		for _, event := range hook.Events {
+			if !slices.Contains(hookEvents, event) {
+				return fmt.Errorf("hook %d has unknown event %q", i+1, event)
+			}
+		}
+		for _, account := range hook.Accounts {
+			if _, ok := cfg.LookupAccount(account); !ok {
+				return fmt.Errorf("hook %d references unknown account %q", i+1, account)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package daemon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/browser"
+	"github.com/vybraan/vygrant/internal/notify"
+	"golang.org/x/oauth2"
+)
+
+// pendingLogins remembers when the daemon started a login for an account, so
+// concurrent requests open the browser or notify only once per login, and hands the
+// outcome of the OAuth2 callback to the requests waiting for it.
+type pendingLogins struct {
+	mu      sync.Mutex
+	opened  map[string]time.Time
+	results map[string]*loginResult
+}
+
+// loginResult is the outcome of the next callback for an account. done is closed once
+// it completed; err is set when it failed.
+type loginResult struct {
+	done chan struct{}
+	err  error
+}
+
+var logins = &pendingLogins{opened: map[string]time.Time{}, results: map[string]*loginResult{}}
+
+// claim reports whether the browser should be opened for account, which is the case
+// unless it was opened less than window ago.
+func (p *pendingLogins) claim(account string, window time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if opened, ok := p.opened[account]; ok && time.Since(opened) < window {
+		return false
+	}
+	p.opened[account] = time.Now()
+	return true
+}
+
+func (p *pendingLogins) done(account string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.opened, account)
+}
+
+// wait returns the result of the next callback for account. Waiters register before
+// the browser opens, so a fast callback cannot be missed.
+func (p *pendingLogins) wait(account string) *loginResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result, ok := p.results[account]
+	if !ok {
+		result = &loginResult{done: make(chan struct{})}
+		p.results[account] = result
+	}
+	return result
+}
+
+// complete wakes every request waiting for the callback of account; authEvent calls
+// it once the callback was handled.
+func (p *pendingLogins) complete(account string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result, ok := p.results[account]
+	if !ok {
+		return
+	}
+	delete(p.results, account)
+	result.err = err
+	close(result.done)
+}
+
+// awaitLogin calls open with the auth URL of account and waits until the OAuth2
+// callback for account completes, timeout passes or cancel is closed. It returns the
+// token stored by the callback. For a scope set or a token exchange account the
+// account they derive from is authorized and their own token is returned.
+func (d *Daemon) awaitLogin(account string, timeout time.Duration, open func(url string) error, cancel <-chan struct{}) (*oauth2.Token, error) {
+	if _, ok := d.Config.LookupAccount(account); !ok {
+		return nil, ErrAccountNotFound
+	}
+	if !d.hasLogin(account) {
+		return nil, fmt.Errorf("'%s' obtains its tokens without a login", account)
+	}
+	base := d.loginAccount(account)
+	result := logins.wait(base)
+	if err := open(d.authURL(base)); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-result.done:
+		if result.err != nil {
+			return nil, fmt.Errorf("authorization failed: %w", result.err)
+		}
+		return d.accessToken(account, false)
+	case <-timer.C:
+		return nil, fmt.Errorf("no authorization within %s", timeout)
+	case <-cancel:
+		return nil, errors.New("login cancelled")
+	}
+}
+
+// autoLogin runs loginAndWait with login_timeout when auto_login is set, giving up
+// when the client on conn hangs up. It reports false when auto_login is off or the
+// login failed, so the caller can answer as it would without it.
+func (d *Daemon) autoLogin(conn net.Conn, account string) (*oauth2.Token, bool) {
+	if !d.Config.AutoLogin {
+		return nil, false
+	}
+	hangup, stop := watchHangup(conn)
+	defer stop()
+	token, err := d.loginAndWait(account, d.Config.LoginWait(), hangup)
+	if err != nil {
+		slog.Warn("automatic login failed", "account", account, "error", err)
+		return nil, false
+	}
+	return token, true
+}
+
+// loginAndWait asks the user to authenticate account, by opening the browser when
+// auto_login is set and with an auth_required notification otherwise, and waits up
+// to timeout for the callback or until cancel is closed. Concurrent callers for one
+// account share the login.
+func (d *Daemon) loginAndWait(account string, timeout time.Duration, cancel <-chan struct{}) (*oauth2.Token, error) {
+	claimed := false
+	login := d.loginAccount(account)
+	open := func(url string) error {
+		if !logins.claim(login, timeout) {
+			slog.Info("waiting for pending login", "account", account)
+			return nil
+		}
+		claimed = true
+		if d.Config.AutoLogin {
+			slog.Info("opening browser for login", "account", account)
+			return browser.Open(d.Config.Browser, url)
+		}
+		Notify(notify.Notification{
+			Event: notifyAuthRequired, Account: account, Severity: notify.Warning,
+			Title:   "vygrant - login required",
+			Message: fmt.Sprintf("A program is waiting for a token for '%s'. Authenticate at: %s", account, url),
+		})
+		return nil
+	}
+	token, err := d.awaitLogin(account, timeout, open, cancel)
+	if claimed {
+		logins.done(login)
+	}
+	return token, err
+}
+
+// streamLogin serves the login command: it writes "URL <auth url>" for the client to
+// open, then the new access token once the callback completes, or an error. The
+// login is cancelled when the client disconnects.
+func (d *Daemon) streamLogin(conn net.Conn, scanner *bufio.Scanner, account string, timeout time.Duration) {
+	disconnected := watchDisconnect(scanner)
+	defer func() {
+		conn.Close()
+		<-disconnected
+	}()
+
+	open := func(url string) error {
+		writeResponse(conn, "URL %s", url)
+		return nil
+	}
+	token, err := d.awaitLogin(account, timeout, open, disconnected)
+	if err != nil {
+		writeError(conn, "Login for '%s' failed: %v", account, err)
+		return
+	}
+	writeResponse(conn, "%s", token.AccessToken)
+}
+
+// writeTokenAfterLogin serves get-token --wait for an account without a usable
+// token: it waits up to wait for a login and writes the new access token. The wait
+// ends early when the client hangs up.
+func (d *Daemon) writeTokenAfterLogin(conn net.Conn, account string, wait time.Duration) {
+	hangup, stop := watchHangup(conn)
+	defer stop()
+	token, err := d.loginAndWait(account, wait, hangup)
+	if err != nil {
+		writeError(conn, "Could not retrieve token for '%s': %v. Authenticate at: %s", account, err, d.authURL(account))
+		return
+	}
+	writeResponse(conn, "%s", token.AccessToken)
+}
+
+// parseWait parses the --wait[=timeout] option of get-token; a bare --wait waits
+// for fallback.
+func parseWait(arg string, fallback time.Duration) (time.Duration, error) {
+	if arg == "--wait" {
+		return fallback, nil
+	}
+	value, ok := strings.CutPrefix(arg, "--wait=")
+	if !ok {
+		return 0, fmt.Errorf("unknown option %q", arg)
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	return wait, nil
+}
+
+// watchDisconnect returns a channel that is closed once the client of a streaming
+// command disconnects. The client sends nothing after the command, so the read only
+// returns at that point.
+func watchDisconnect(scanner *bufio.Scanner) <-chan struct{} {
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for scanner.Scan() {
+		}
+	}()
+	return disconnected
+}
@@ -0,0 +1,273 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/notify"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func loginDaemon() *Daemon {
+	return &Daemon{
+		Config: &config.Config{
+			HTTPListen: "8080",
+			Accounts: map[string]*config.Account{
+				"work": {RedirectURI: "http://localhost:8080/callback"},
+				"jwt":  {Grant: config.GrantJWTBearer},
+			},
+		},
+		TokenStore: storage.NewMemoryStore(),
+	}
+}
+
+// completeLogin stores a token for account and reports the callback as handled.
+func completeLogin(d *Daemon, account, accessToken string) {
+	d.TokenStore.Set(account, &oauth2.Token{AccessToken: accessToken, Expiry: time.Now().Add(time.Hour)})
+	authEvent(account, "auth_completed", nil)
+}
+
+func TestPendingLoginsClaim(t *testing.T) {
+	p := &pendingLogins{opened: map[string]time.Time{}, results: map[string]*loginResult{}}
+	if !p.claim("work", time.Minute) {
+		t.Fatal("first claim refused")
+	}
+	if p.claim("work", time.Minute) {
+		t.Error("second claim within the window allowed")
+	}
+	if !p.claim("home", time.Minute) {
+		t.Error("claim for another account refused")
+	}
+	if !p.claim("home", 0) {
+		t.Error("claim after the window refused")
+	}
+	p.done("work")
+	if !p.claim("work", time.Minute) {
+		t.Error("claim after done refused")
+	}
+}
+
+func TestPendingLoginsComplete(t *testing.T) {
+	p := &pendingLogins{opened: map[string]time.Time{}, results: map[string]*loginResult{}}
+	first, second := p.wait("work"), p.wait("work")
+	other := p.wait("home")
+	p.complete("work", errors.New("denied"))
+	for _, result := range []*loginResult{first, second} {
+		select {
+		case <-result.done:
+			if result.err == nil || result.err.Error() != "denied" {
+				t.Errorf("err = %v, want denied", result.err)
+			}
+		default:
+			t.Error("waiter was not woken")
+		}
+	}
+	select {
+	case <-other.done:
+		t.Error("waiter for another account was woken")
+	default:
+	}
+	if next := p.wait("work"); next == first {
+		t.Error("a completed result is reused for the next login")
+	}
+	p.complete("nobody", nil)
+}
+
+func TestAwaitLoginReturnsTokenOfCompletedCallback(t *testing.T) {
+	d := loginDaemon()
+	var opened string
+	open := func(url string) error {
+		opened = url
+		// The callback may complete before awaitLogin starts waiting.
+		completeLogin(d, "work", "fresh")
+		return nil
+	}
+	token, err := d.awaitLogin("work", time.Minute, open, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "fresh" {
+		t.Errorf("token = %q, want fresh", token.AccessToken)
+	}
+	if opened != "http://localhost:8080/auth?account=work" {
+		t.Errorf("opened %q, want the auth URL of work", opened)
+	}
+}
+
+func TestAwaitLoginFailures(t *testing.T) {
+	d := loginDaemon()
+	noop := func(string) error { return nil }
+
+	failed := func(string) error {
+		authEvent("work", "auth_failed", errors.New("access_denied"))
+		return nil
+	}
+	if _, err := d.awaitLogin("work", time.Minute, failed, nil); err == nil || !strings.Contains(err.Error(), "authorization failed: access_denied") {
+		t.Errorf("failed callback: err = %v", err)
+	}
+
+	start := time.Now()
+	if _, err := d.awaitLogin("work", 50*time.Millisecond, noop, nil); err == nil || !strings.Contains(err.Error(), "no authorization within 50ms") {
+		t.Errorf("timeout: err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timeout took %v", elapsed)
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if _, err := d.awaitLogin("work", time.Minute, noop, cancel); err == nil || err.Error() != "login cancelled" {
+		t.Errorf("cancel: err = %v", err)
+	}
+
+	if _, err := d.awaitLogin("missing", time.Minute, noop, nil); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("unknown account: err = %v", err)
+	}
+	if _, err := d.awaitLogin("jwt", time.Minute, noop, nil); err == nil {
+		t.Error("login accepted for an account without a browser login")
+	}
+	openErr := errors.New("no browser")
+	if _, err := d.awaitLogin("work", time.Minute, func(string) error { return openErr }, nil); !errors.Is(err, openErr) {
+		t.Errorf("open failure: err = %v", err)
+	}
+}
+
+// silenceNotifications drops notifications until the test ends. Notifications sent
+// meanwhile keep the silent notifier even when they are delivered afterwards.
+func silenceNotifications(t *testing.T) {
+	notifier, reauthURL := currentNotifier()
+	setNotifier(notify.None{}, nil)
+	t.Cleanup(func() { setNotifier(notifier, reauthURL) })
+}
+
+func TestLoginAndWaitSharesOneLogin(t *testing.T) {
+	silenceNotifications(t)
+	d := loginDaemon()
+	const waiters = 3
+	var wg sync.WaitGroup
+	tokens := make(chan string, waiters)
+	for range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := d.loginAndWait("work", time.Minute, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			tokens <- token.AccessToken
+		}()
+	}
+
+	// Complete the login until every waiter got it, as some may register late.
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		select {
+		case <-finished:
+			waiting = false
+		case <-ticker.C:
+			completeLogin(d, "work", "shared")
+		}
+	}
+	close(tokens)
+
+	count := 0
+	for token := range tokens {
+		count++
+		if token != "shared" {
+			t.Errorf("token = %q, want shared", token)
+		}
+	}
+	if count != waiters {
+		t.Errorf("%d of %d waiters got the token", count, waiters)
+	}
+	logins.mu.Lock()
+	defer logins.mu.Unlock()
+	if _, ok := logins.opened["work"]; ok {
+		t.Error("login is still marked as opened")
+	}
+}
+
+func TestParseWait(t *testing.T) {
+	const fallback = 2 * time.Minute
+	tests := []struct {
+		arg     string
+		want    time.Duration
+		wantErr string
+	}{
+		{"--wait", fallback, ""},
+		{"--wait=30s", 30 * time.Second, ""},
+		{"--wait=1h", time.Hour, ""},
+		{"--wait=0", 0, `invalid timeout "0"`},
+		{"--wait=-5s", 0, `invalid timeout "-5s"`},
+		{"--wait=abc", 0, `invalid timeout "abc"`},
+		{"--wait=", 0, `invalid timeout ""`},
+		{"--force", 0, `unknown option "--force"`},
+		{"--waits", 0, `unknown option "--waits"`},
+	}
+	for _, tt := range tests {
+		got, err := parseWait(tt.arg, fallback)
+		gotErr := ""
+		if err != nil {
+			gotErr = err.Error()
+		}
+		if got != tt.want || gotErr != tt.wantErr {
+			t.Errorf("parseWait(%q) = %v, %q; want %v, %q", tt.arg, got, gotErr, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetTokenWaitEndsWhenClientHangsUp(t *testing.T) {
+	silenceNotifications(t)
+	d := loginDaemon()
+	conn := dialDaemon(t, d)
+
+	conn.Write([]byte("get-token work --wait=1m\n"))
+	conn.(*net.UnixConn).CloseWrite()
+	waitForLoginClaim(t, "work")
+	conn.Close()
+
+	// The handler gives up the login instead of holding it for a minute.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logins.mu.Lock()
+		_, pending := logins.opened["work"]
+		logins.mu.Unlock()
+		if !pending {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("get-token --wait kept waiting after the client hung up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForLoginClaim(t *testing.T, account string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logins.mu.Lock()
+		_, claimed := logins.opened[account]
+		logins.mu.Unlock()
+		if claimed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no login started for %s", account)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
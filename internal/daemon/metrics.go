@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/vybraan/vygrant/internal/metrics"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	tokenExpiry = metricsRegistry.NewGaugeVec(
+		"vygrant_token_expiry_timestamp_seconds",
+		"Unix time at which the cached access token of an account expires.",
+		"account")
+	refreshTotal = metricsRegistry.NewCounterVec(
+		"vygrant_token_refresh_total",
+		"Token refresh attempts by result.",
+		"account", "result")
+	refreshFailures = metricsRegistry.NewCounterVec(
+		"vygrant_token_refresh_failures_total",
+		"Failed token refreshes by error class.",
+		"account", "error_class")
+	socketCommands = metricsRegistry.NewCounterVec(
+		"vygrant_socket_commands_total",
+		"Commands received on the control socket.",
+		"command")
+	socketCommandDuration = metricsRegistry.NewHistogramVec(
+		"vygrant_socket_command_duration_seconds",
+		"Time spent handling control socket commands.",
+		metrics.DefaultBuckets,
+		"command")
+	backgroundCheckDuration = metricsRegistry.NewHistogramVec(
+		"vygrant_background_check_duration_seconds",
+		"Duration of background expiring-token checks.",
+		metrics.DefaultBuckets)
+	storageErrors = metricsRegistry.NewCounterVec(
+		"vygrant_storage_errors_total",
+		"Token storage backend operation errors.",
+		"backend", "operation")
+)
+
+// socketCommandNames bounds the command label to commands the daemon knows.
+var socketCommandNames = map[string]bool{
+	"accounts":       true,
+	"status":         true,
+	"info":           true,
+	"get-token":      true,
+	"delete-token":   true,
+	"refresh-token":  true,
+	"dump-tokens":    true,
+	"restore-tokens": true,
+	"subscribe":      true,
+	"login":          true,
+	"proxy-env":      true,
+}
+
+func commandMetricLabel(command string) string {
+	if socketCommandNames[command] {
+		return command
+	}
+	return "unknown"
+}
+
+// recordRefresh counts a refresh attempt for account and classifies err.
+func recordRefresh(account string, err error) {
+	if err == nil {
+		refreshTotal.Inc(account, "success")
+		return
+	}
+	refreshTotal.Inc(account, "failure")
+	refreshFailures.Inc(account, refreshErrorClass(err))
+}
+
+// refreshErrorClass maps a refresh error to a small set of classes: the OAuth2 error
+// code when the provider sent one, otherwise http_4xx, http_5xx, timeout, network,
+// config or other.
+func refreshErrorClass(err error) string {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.ErrorCode != "" {
+			return retrieveErr.ErrorCode
+		}
+		if retrieveErr.Response != nil {
+			return fmt.Sprintf("http_%dxx", retrieveErr.Response.StatusCode/100)
+		}
+	}
+	if errors.Is(err, ErrAccountNotFound) {
+		return "config"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return "network"
+	}
+	return "other"
+}
+
+func recordStorageError(backend, operation string, err error) {
+	storageErrors.Inc(backend, operation)
+	slog.Warn("storage operation failed", "backend", backend, "operation", operation, "error", err)
+}
+
+// observeTokenExpiry is a storage.ChangeFunc that keeps the expiry gauge in sync.
+func observeTokenExpiry(store storage.TokenStore) storage.ChangeFunc {
+	update := func(account string) {
+		token, err := store.Get(account)
+		if err != nil || token.Expiry.IsZero() {
+			tokenExpiry.Delete(account)
+			return
+		}
+		tokenExpiry.Set(float64(token.Expiry.Unix()), account)
+	}
+	return func(account, event string) {
+		if account == "*" {
+			for _, name := range store.ListAccounts() {
+				update(name)
+			}
+			return
+		}
+		update(account)
+	}
+}
+
+// startMetricsServer serves the metrics registry on localhost:port.
+func startMetricsServer(port string, errCh chan<- error) (*http.Server, error) {
+	listener, err := net.Listen("tcp", "localhost:"+port)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listener failed: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metricsRegistry.Handler())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			errCh <- fmt.Errorf("metrics server crashed: %w", err)
+		}
+	}()
+	slog.Info("metrics listening", "addr", listener.Addr().String())
+	return server, nil
+}
@@ -2,29 +2,92 @@ package daemon
 
 import (
 	"fmt"
-	"log"
-	"os/exec"
-	"runtime"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/notify"
+)
+
+// Notification events. Their severity can be changed in [notify.severity].
+const (
+	notifyRefreshOK       = "refresh_ok"
+	notifyRefreshFailed   = "refresh_failed"
+	notifyRefreshRejected = "refresh_rejected"
+	notifyRefreshRotated  = "refresh_rotated"
+	notifyAuthRequired    = "auth_required"
 )
 
-func Notify(title, message string) {
-	var cmd *exec.Cmd
-
-	switch runtime.GOOS {
-	case "linux":
-		cmd = exec.Command("notify-send", title, message)
-	case "darwin":
-		script := fmt.Sprintf(`display notification %q with title %q`, message, title)
-		cmd = exec.Command("osascript", "-e", script)
-	case "windows":
-		ps := fmt.Sprintf(`[reflection.assembly]::LoadWithPartialName('System.Windows.Forms');`+
-			`[System.Windows.Forms.MessageBox]::Show('%s','%s')`, message, title)
-		cmd = exec.Command("powershell", "-Command", ps)
+var notifyEvents = []string{
+	notifyRefreshOK, notifyRefreshFailed, notifyRefreshRejected, notifyRefreshRotated, notifyAuthRequired,
+}
+
+// validateNotify checks the [notify] section, including that [notify.severity] only
+// names notification events.
+func validateNotify(cfg *config.Config) error {
+	if err := notify.Validate(cfg.Notify); err != nil {
+		return err
+	}
+	events := make([]string, 0, len(cfg.Notify.Severity))
+	for event := range cfg.Notify.Severity {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		if !slices.Contains(notifyEvents, event) {
+			return fmt.Errorf("notify severity has unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// notifications holds the notifier that delivers notifications and the function
+// that builds the Re-authenticate action of notifications asking for a new
+// authorization. Start configures both with setNotifier.
+var notifications = struct {
+	sync.Mutex
+	notifier  notify.Notifier
+	reauthURL func(account string) string
+}{notifier: notify.Desktop{}}
+
+// setNotifier replaces the notifier and the Re-authenticate URL builder.
+// Notifications already sent keep the notifier they were sent with.
+func setNotifier(notifier notify.Notifier, reauthURL func(account string) string) {
+	notifications.Lock()
+	defer notifications.Unlock()
+	notifications.notifier, notifications.reauthURL = notifier, reauthURL
+}
+
+func currentNotifier() (notify.Notifier, func(account string) string) {
+	notifications.Lock()
+	defer notifications.Unlock()
+	return notifications.notifier, notifications.reauthURL
+}
+
+// forgetNotifications lets the next notification for account through the rate limit
+// and deduplication, for example after the account recovered.
+func forgetNotifications(account string) {
+	notifier, _ := currentNotifier()
+	if filter, ok := notifier.(*notify.Filter); ok {
+		filter.Forget(account)
 	}
+}
 
-	if cmd != nil {
-		if err := cmd.Run(); err != nil {
-			log.Printf("notification failed: %v", err)
+// Notify sends n in the background. Notifications for events that need a new
+// authorization get the account's /auth URL as action.
+func Notify(n notify.Notification) {
+	notifier, reauthURL := currentNotifier()
+	if reauthURL != nil && n.Account != "" {
+		switch n.Event {
+		case notifyRefreshRejected, notifyRefreshRotated, notifyAuthRequired:
+			n.ActionURL = reauthURL(n.Account)
 		}
 	}
+	go func() {
+		if err := notifier.Notify(n); err != nil {
+			slog.Warn("notification failed", "event", n.Event, "account", n.Account, "error", err)
+		}
+	}()
 }
@@ -0,0 +1,30 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+func TestValidateNotifySeverityEvents(t *testing.T) {
+	tests := []struct {
+		severity map[string]string
+		wantErr  string
+	}{
+		{map[string]string{"refresh_ok": "off", "auth_required": "error", "refresh_rotated": "warning"}, ""},
+		{map[string]string{"refresh_rejected": "info", "refresh_failed": "error"}, ""},
+		{map[string]string{"refresh_ok": "off", "refresh_fail": "error"}, `notify severity has unknown event "refresh_fail"`},
+		{map[string]string{"token_set": "info"}, `notify severity has unknown event "token_set"`},
+		{map[string]string{"refresh_ok": "loud"}, `notify severity for refresh_ok: unknown severity "loud" (want info, warning or error)`},
+	}
+	for _, tt := range tests {
+		err := validateNotify(&config.Config{Notify: config.Notify{Severity: tt.severity}})
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.wantErr {
+			t.Errorf("validateNotify(%v) = %q, want %q", tt.severity, got, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vybraan/vygrant/internal/api"
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+// startProxies starts the forward proxy and every reverse proxy configured in
+// cfg.Proxy. Serve errors are reported on errCh. The returned servers must be shut
+// down by the caller; on error any listener opened so far is closed.
+//
+// Every start creates a new secret that clients must present; the proxy-env socket
+// command hands it to the users allowed on the socket.
+func (d *Daemon) startProxies(errCh chan<- error) ([]*http.Server, error) {
+	type proxyListener struct {
+		name     string
+		listener net.Listener
+		handler  http.Handler
+	}
+
+	if IsListenerEnabled(d.Config.Proxy.Listen) || len(d.Config.Proxy.Reverse) > 0 {
+		d.proxySecret = rand.Text()
+	}
+
+	var listeners []proxyListener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.listener.Close()
+		}
+	}
+
+	if IsListenerEnabled(d.Config.Proxy.Listen) {
+		listener, err := net.Listen("tcp", "localhost:"+d.Config.Proxy.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("proxy listener failed: %w", err)
+		}
+		listeners = append(listeners, proxyListener{
+			name:     "proxy",
+			listener: listener,
+			handler:  api.ForwardProxy(d.Config.Proxy.Rules, d.auditedToken("proxy"), d.proxySecret),
+		})
+	}
+
+	for _, reverse := range d.Config.Proxy.Reverse {
+		upstream, err := url.Parse(reverse.Upstream)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("reverse proxy upstream %q: %w", reverse.Upstream, err)
+		}
+		listener, err := net.Listen("tcp", "localhost:"+reverse.Listen)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("reverse proxy listener for %s failed: %w", reverse.Upstream, err)
+		}
+		listeners = append(listeners, proxyListener{
+			name:     "reverse proxy " + reverse.Upstream,
+			listener: listener,
+			handler:  api.ReverseProxy(upstream, reverse.Account, d.auditedToken("proxy"), d.proxySecret),
+		})
+	}
+
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, l := range listeners {
+		server := &http.Server{Handler: l.handler}
+		servers = append(servers, server)
+		go func() {
+			if err := server.Serve(l.listener); err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("%s crashed: %w", l.name, err)
+			}
+		}()
+		slog.Info("proxy listening", "proxy", l.name, "addr", l.listener.Addr().String())
+	}
+	return servers, nil
+}
+
+// proxyEnv returns shell assignments that point clients at the proxies with the
+// secret of this start.
+func (d *Daemon) proxyEnv() (string, error) {
+	if d.proxySecret == "" {
+		return "", fmt.Errorf("no proxies are configured")
+	}
+	var b strings.Builder
+	if IsListenerEnabled(d.Config.Proxy.Listen) {
+		proxyURL := (&url.URL{
+			Scheme: "http",
+			User:   url.UserPassword(api.ProxyUser, d.proxySecret),
+			Host:   "localhost:" + d.Config.Proxy.Listen,
+		}).String()
+		fmt.Fprintf(&b, "export http_proxy=%s\nexport HTTP_PROXY=%s\n", proxyURL, proxyURL)
+	}
+	fmt.Fprintf(&b, "export VYGRANT_PROXY_SECRET=%s\n", d.proxySecret)
+	return b.String(), nil
+}
+
+// validateProxyConfig checks that every proxy rule and reverse proxy refers to a
+// configured account, that proxy rules only name loopback hosts and that reverse
+// proxy upstreams are absolute http(s) URLs.
+func validateProxyConfig(cfg *config.Config) error {
+	for i, rule := range cfg.Proxy.Rules {
+		if rule.Host == "" {
+			return fmt.Errorf("proxy rule %d is missing host", i+1)
+		}
+		if _, ok := cfg.LookupAccount(rule.Account); !ok {
+			return fmt.Errorf("proxy rule %d refers to unknown account %q", i+1, rule.Account)
+		}
+		if !api.IsLoopbackHost(ruleHostname(rule.Host)) {
+			return fmt.Errorf("proxy rule %d host %q is not a loopback host; the forward proxy only sends tokens over plain http, so use a reverse proxy with an https upstream", i+1, rule.Host)
+		}
+	}
+	for _, reverse := range cfg.Proxy.Reverse {
+		if !IsListenerEnabled(reverse.Listen) {
+			return fmt.Errorf("reverse proxy for %q is missing listen", reverse.Upstream)
+		}
+		if _, ok := cfg.LookupAccount(reverse.Account); !ok {
+			return fmt.Errorf("reverse proxy for %q refers to unknown account %q", reverse.Upstream, reverse.Account)
+		}
+		parsed, err := url.ParseRequestURI(reverse.Upstream)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("reverse proxy upstream %q must be an absolute http or https URL", reverse.Upstream)
+		}
+	}
+	return nil
+}
+
+// ruleHostname returns the host name of a proxy rule host, without its port and
+// "*." prefix.
+func ruleHostname(host string) string {
+	host = strings.TrimPrefix(strings.TrimSpace(host), "*.")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
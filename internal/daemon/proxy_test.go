@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+func TestValidateProxyConfigRuleHosts(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{"localhost", false},
+		{"localhost:8080", false},
+		{"*.localhost", false},
+		{"127.0.0.1:9000", false},
+		{"[::1]:9000", false},
+		{"api.example.com", true},
+		{"*.example.com", true},
+		{"10.0.0.5:8080", true},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{
+			Accounts: map[string]*config.Account{"work": {}},
+			Proxy:    config.Proxy{Rules: []config.ProxyRule{{Host: tt.host, Account: "work"}}},
+		}
+		if err := validateProxyConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("validateProxyConfig(host %q) = %v, want error %v", tt.host, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/notify"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+const (
+	refreshAttempts     = 4
+	refreshBackoffBase  = time.Second
+	refreshBackoffLimit = 30 * time.Second
+	// socketRefreshWait bounds how long a socket command waits for a refresh that
+	// is retrying; the refresh itself finishes in the background.
+	socketRefreshWait = 5 * time.Second
+)
+
+// retrySleep waits between refresh attempts; tests replace it.
+var retrySleep = time.Sleep
+
+// errRefreshPending is returned to callers that stopped waiting for a refresh.
+var errRefreshPending = errors.New("refresh is still being retried; try again shortly")
+
+type refreshErrorKind string
+
+const (
+	// refreshRejected means the provider definitively refused the refresh token
+	// (invalid_grant); only a new authorization can recover.
+	refreshRejected refreshErrorKind = "rejected"
+	// refreshTransient covers network failures, timeouts, 429 and 5xx responses.
+	refreshTransient refreshErrorKind = "transient"
+	// refreshFailed is any other error, such as invalid_client or a bad config.
+	refreshFailed refreshErrorKind = "failed"
+	// refreshRotated is a rejection of a refresh token that the provider rotates,
+	// which means another client spent it first.
+	refreshRotated refreshErrorKind = "rotated"
+)
+
+// reuseHints are fragments of provider error descriptions that report a reused
+// refresh token. "revoked" is not one: Google, for one, answers "Token has been
+// expired or revoked." for ordinary expiry.
+var reuseHints = []string{"reuse", "rotated", "already used", "already been used"}
+
+// classifyRejection upgrades an invalid_grant to refreshRotated when the provider
+// says the refresh token was reused. A provider that rotates refresh tokens also
+// rejects them for expiry or revocation, so rotation alone is not enough.
+func classifyRejection(err error) error {
+	if refreshErrorKindOf(err) != refreshRejected {
+		return err
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return err
+	}
+	description := strings.ToLower(retrieveErr.ErrorDescription)
+	for _, hint := range reuseHints {
+		if strings.Contains(description, hint) {
+			return &refreshError{kind: refreshRotated, err: err}
+		}
+	}
+	return err
+}
+
+func classifyRefreshError(err error) refreshErrorKind {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.ErrorCode == "invalid_grant" {
+			return refreshRejected
+		}
+		if retrieveErr.Response != nil {
+			status := retrieveErr.Response.StatusCode
+			if status == http.StatusTooManyRequests || status >= 500 {
+				return refreshTransient
+			}
+		}
+		return refreshFailed
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return refreshTransient
+	}
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+		return refreshTransient
+	}
+	return refreshFailed
+}
+
+// refreshError wraps a refresh failure with its classification.
+type refreshError struct {
+	kind refreshErrorKind
+	err  error
+}
+
+func (e *refreshError) Error() string {
+	return e.err.Error()
+}
+
+func (e *refreshError) Unwrap() error {
+	return e.err
+}
+
+// isRefreshRejected reports whether err is a definitive rejection of the refresh token.
+func isRefreshRejected(err error) bool {
+	kind := refreshErrorKindOf(err)
+	return kind == refreshRejected || kind == refreshRotated
+}
+
+// refreshErrorKindOf returns the classification of a refreshAccount error.
+func refreshErrorKindOf(err error) refreshErrorKind {
+	var refreshErr *refreshError
+	if errors.As(err, &refreshErr) {
+		return refreshErr.kind
+	}
+	return refreshFailed
+}
+
+// refreshWithRetry calls RefreshToken, retrying transient failures with exponential
+// backoff and full jitter.
+func refreshWithRetry(account string, cfg *config.Config, token *oauth2.Token, httpClient *http.Client) (*oauth2.Token, error) {
+	return withRetry(account, func() (*oauth2.Token, error) {
+		return RefreshToken(account, cfg, token, httpClient)
+	})
+}
+
+// withRetry calls request for account until it succeeds, fails with an error that is
+// not transient or runs out of attempts. The error is a *refreshError.
+func withRetry(account string, request func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	var err error
+	for attempt := 0; attempt < refreshAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := min(refreshBackoffBase<<(attempt-1), refreshBackoffLimit)
+			delay := rand.N(backoff) + time.Millisecond
+			slog.Info("retrying token refresh", "account", account, "attempt", attempt+1, "delay", delay, "error", err)
+			retrySleep(delay)
+		}
+		var newToken *oauth2.Token
+		newToken, err = request()
+		if err == nil {
+			return newToken, nil
+		}
+		if kind := classifyRefreshError(err); kind != refreshTransient {
+			return nil, &refreshError{kind: kind, err: err}
+		}
+	}
+	return nil, &refreshError{kind: refreshTransient, err: err}
+}
+
+// awaitRefresh runs refresh and returns its result, or a transient errRefreshPending
+// once wait has passed. The refresh is not cancelled, since a token endpoint may
+// already have rotated the refresh token that is being spent.
+func awaitRefresh(wait time.Duration, refresh func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	type result struct {
+		token *oauth2.Token
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		token, err := refresh()
+		done <- result{token, err}
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.token, r.err
+	case <-timer.C:
+		return nil, &refreshError{kind: refreshTransient, err: errRefreshPending}
+	}
+}
+
+// refreshAccount refreshes token for account and records the outcome in
+// refreshStates. A new token is saved to store. When the provider rejects the
+// refresh token it is deleted from store; any other failure leaves it in place so a
+// later attempt can succeed.
+//
+// Concurrent calls for the same account share one refresh through refreshFlights,
+// and the refresh holds the account's refreshTokenLocks entry. A caller whose token
+// was already replaced by a finished refresh gets the stored token instead of
+// spending the old refresh token again.
+func refreshAccount(account string, cfg *config.Config, store storage.TokenStore, httpClient *http.Client, token *oauth2.Token) (*oauth2.Token, error) {
+	return refreshFlights.do(account, func() (*oauth2.Token, error) {
+		unlock := refreshTokenLocks.lock(account)
+		defer unlock()
+		if current, err := store.Get(account); err == nil {
+			if current.AccessToken != token.AccessToken && current.Valid() {
+				return current, nil
+			}
+			// A scope set refresh may have rotated the refresh token meanwhile.
+			if current.RefreshToken != "" {
+				token = current
+			}
+		}
+		return refreshAndStore(account, cfg, store, httpClient, token)
+	})
+}
+
+func refreshAndStore(account string, cfg *config.Config, store storage.TokenStore, httpClient *http.Client, token *oauth2.Token) (*oauth2.Token, error) {
+	newToken, err := refreshWithRetry(account, cfg, token, httpClient)
+	if err != nil {
+		err = classifyRejection(err)
+	} else {
+		if newToken.RefreshToken == "" {
+			// No refresh token in the response means the current one stays valid.
+			newToken.RefreshToken = token.RefreshToken
+		}
+		if newToken.RefreshToken != token.RefreshToken {
+			refreshStates.rotated(account)
+			slog.Debug("refresh token rotated", "account", account)
+		}
+	}
+	refreshStates.record(account, err)
+	publishRefresh(account, newToken, err)
+	if err != nil {
+		if isRefreshRejected(err) {
+			if err := store.Delete(account); err != nil {
+				slog.Error("failed to delete rejected token", "account", account, "error", err)
+			}
+		}
+		return nil, err
+	}
+	forgetNotifications(account)
+	if err := store.Set(account, newToken); err != nil {
+		slog.Error("failed to save refreshed token", "account", account, "error", err)
+	}
+	return newToken, nil
+}
+
+// notifyRefreshed tells the user that the token of account was refreshed on request.
+func notifyRefreshed(account string) {
+	Notify(notify.Notification{
+		Event: notifyRefreshOK, Account: account, Severity: notify.Info,
+		Title:   "vygrant - token refreshed",
+		Message: fmt.Sprintf("Token for '%s' successfully refreshed.", account),
+	})
+}
+
+// notifyRefreshFailure tells the user that account could not be refreshed and whether
+// a new authorization is needed.
+func notifyRefreshFailure(account string, err error) {
+	switch refreshErrorKindOf(err) {
+	case refreshRotated:
+		Notify(notify.Notification{
+			Event: notifyRefreshRotated, Account: account, Severity: notify.Error,
+			Title:   "vygrant - refresh token rotated",
+			Message: fmt.Sprintf("The refresh token for '%s' was rotated elsewhere, probably by another client using the same grant. Please re-authenticate.", account),
+		})
+	case refreshRejected:
+		Notify(notify.Notification{
+			Event: notifyRefreshRejected, Account: account, Severity: notify.Error,
+			Title:   "vygrant - auto refresh failed",
+			Message: fmt.Sprintf("Token for '%s' was rejected by the provider and has been deleted. Please re-authenticate.", account),
+		})
+	default:
+		Notify(notify.Notification{
+			Event: notifyRefreshFailed, Account: account, Severity: notify.Warning,
+			Title:   "vygrant - auto refresh failed",
+			Message: fmt.Sprintf("Token for '%s' could not be refreshed; it will be retried.", account),
+		})
+	}
+}
+
+// refreshState is the last known refresh outcome of an account. Generation counts
+// the refresh tokens the provider issued by rotation since the daemon started.
+type refreshState struct {
+	LastAttempt time.Time
+	LastSuccess time.Time
+	LastError   string
+	ErrorKind   refreshErrorKind
+	Failures    int
+	Generation  int
+}
+
+type refreshStateTracker struct {
+	mu     sync.Mutex
+	states map[string]refreshState
+}
+
+var refreshStates = &refreshStateTracker{states: map[string]refreshState{}}
+
+func (t *refreshStateTracker) record(account string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.states[account]
+	state.LastAttempt = time.Now()
+	if err == nil {
+		state.LastSuccess = state.LastAttempt
+		state.LastError = ""
+		state.ErrorKind = ""
+		state.Failures = 0
+	} else {
+		state.LastError = strings.TrimSpace(err.Error())
+		state.ErrorKind = refreshErrorKindOf(err)
+		state.Failures++
+	}
+	t.states[account] = state
+}
+
+// rotated records that the provider replaced the refresh token of account.
+func (t *refreshStateTracker) rotated(account string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.states[account]
+	state.Generation++
+	t.states[account] = state
+}
+
+// reset forgets the refresh history of account, for example after a new authorization
+// replaced its tokens.
+func (t *refreshStateTracker) reset(account string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, account)
+}
+
+func (t *refreshStateTracker) get(account string) (refreshState, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.states[account]
+	return state, ok
+}
@@ -0,0 +1,238 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+// noRetrySleep replaces retrySleep for the test and returns the delays it was asked
+// to wait.
+func noRetrySleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var delays []time.Duration
+	previous := retrySleep
+	retrySleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { retrySleep = previous })
+	return &delays
+}
+
+// failingEndpoint answers the first failures requests with status and body, then
+// issues a token.
+func failingEndpoint(t *testing.T, failures int32, status int, body map[string]any) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if requests.Add(1) <= failures {
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(body)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "new-access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// retryConfig configures account with a fixed token endpoint auth method, since
+// x/oauth2 repeats a failed request to detect one.
+func retryConfig(account, tokenURI string) *config.Config {
+	return &config.Config{Accounts: map[string]*config.Account{account: {
+		TokenURI:                tokenURI,
+		TokenEndpointAuthMethod: config.AuthClientSecretPost,
+	}}}
+}
+
+func TestRefreshRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"server error", http.StatusServiceUnavailable},
+		{"rate limited", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays := noRetrySleep(t)
+			endpoint, requests := failingEndpoint(t, refreshAttempts-1, tt.status, map[string]any{"error": "temporarily_unavailable"})
+			account := "retry-" + tt.name
+			store := storage.NewMemoryStore()
+			cfg := retryConfig(account, endpoint.URL)
+
+			token, err := refreshAccount(account, cfg, store, endpoint.Client(), &oauth2.Token{AccessToken: "old", RefreshToken: "refresh"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.AccessToken != "new-access" || token.RefreshToken != "refresh" {
+				t.Errorf("token = %+v, want the new access token and the kept refresh token", token)
+			}
+			if got := requests.Load(); got != refreshAttempts {
+				t.Errorf("requests = %d, want %d", got, refreshAttempts)
+			}
+			if len(*delays) != refreshAttempts-1 {
+				t.Errorf("slept %d times, want %d", len(*delays), refreshAttempts-1)
+			}
+			for i, delay := range *delays {
+				if limit := refreshBackoffBase << i; delay <= 0 || delay > limit+time.Millisecond {
+					t.Errorf("delay %d = %v, want within (0, %v]", i, delay, limit)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshKeepsTokenAfterTransientFailures(t *testing.T) {
+	noRetrySleep(t)
+	endpoint, requests := failingEndpoint(t, refreshAttempts, http.StatusBadGateway, map[string]any{"error": "bad_gateway"})
+	store := storage.NewMemoryStore()
+	stored := &oauth2.Token{AccessToken: "old", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)}
+	if err := store.Set("flaky", stored); err != nil {
+		t.Fatal(err)
+	}
+	cfg := retryConfig("flaky", endpoint.URL)
+
+	_, err := refreshAccount("flaky", cfg, store, endpoint.Client(), stored)
+	if kind := refreshErrorKindOf(err); kind != refreshTransient {
+		t.Fatalf("error kind = %q, want %q (err: %v)", kind, refreshTransient, err)
+	}
+	if got := requests.Load(); got != refreshAttempts {
+		t.Errorf("requests = %d, want %d", got, refreshAttempts)
+	}
+	if token, err := store.Get("flaky"); err != nil || token.RefreshToken != "refresh" {
+		t.Errorf("stored token = %v, %v; want it kept", token, err)
+	}
+}
+
+func TestRefreshRetriesNetworkErrors(t *testing.T) {
+	delays := noRetrySleep(t)
+	endpoint := httptest.NewServer(http.NotFoundHandler())
+	endpoint.Close()
+	store := storage.NewMemoryStore()
+	stored := &oauth2.Token{AccessToken: "old", RefreshToken: "refresh"}
+	if err := store.Set("offline", stored); err != nil {
+		t.Fatal(err)
+	}
+	cfg := retryConfig("offline", endpoint.URL)
+
+	_, err := refreshAccount("offline", cfg, store, http.DefaultClient, stored)
+	if kind := refreshErrorKindOf(err); kind != refreshTransient {
+		t.Fatalf("error kind = %q, want %q (err: %v)", kind, refreshTransient, err)
+	}
+	if len(*delays) != refreshAttempts-1 {
+		t.Errorf("slept %d times, want %d", len(*delays), refreshAttempts-1)
+	}
+	if _, err := store.Get("offline"); err != nil {
+		t.Errorf("token was dropped after network errors: %v", err)
+	}
+}
+
+func TestRefreshDoesNotRetryInvalidGrant(t *testing.T) {
+	delays := noRetrySleep(t)
+	endpoint, requests := failingEndpoint(t, refreshAttempts, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+	store := storage.NewMemoryStore()
+	stored := &oauth2.Token{AccessToken: "old", RefreshToken: "refresh"}
+	if err := store.Set("revoked", stored); err != nil {
+		t.Fatal(err)
+	}
+	cfg := retryConfig("revoked", endpoint.URL)
+
+	_, err := refreshAccount("revoked", cfg, store, endpoint.Client(), stored)
+	if kind := refreshErrorKindOf(err); kind != refreshRejected {
+		t.Fatalf("error kind = %q, want %q (err: %v)", kind, refreshRejected, err)
+	}
+	if got := requests.Load(); got != 1 || len(*delays) != 0 {
+		t.Errorf("requests = %d, sleeps = %d; want a single attempt", got, len(*delays))
+	}
+	if _, err := store.Get("revoked"); err == nil {
+		t.Error("rejected token was not deleted")
+	}
+}
+
+func TestAwaitRefreshStopsWaiting(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	_, err := awaitRefresh(10*time.Millisecond, func() (*oauth2.Token, error) {
+		defer close(finished)
+		<-release
+		return &oauth2.Token{AccessToken: "late"}, nil
+	})
+	if !errors.Is(err, errRefreshPending) || refreshErrorKindOf(err) != refreshTransient {
+		t.Fatalf("err = %v, want a transient errRefreshPending", err)
+	}
+	close(release)
+	<-finished
+
+	token, err := awaitRefresh(time.Second, func() (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "quick"}, nil
+	})
+	if err != nil || token.AccessToken != "quick" {
+		t.Fatalf("awaitRefresh = %v, %v; want the refreshed token", token, err)
+	}
+}
+
+func TestRejectionIsReportedAsRotatedOnlyForReuse(t *testing.T) {
+	tests := []struct {
+		description string
+		want        refreshErrorKind
+	}{
+		{"", refreshRejected},
+		{"Token has been expired or revoked.", refreshRejected},
+		{"Refresh token reuse detected", refreshRotated},
+		{"The refresh token has already been used", refreshRotated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			var requests atomic.Int32
+			tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if requests.Add(1) > 1 {
+					w.WriteHeader(http.StatusBadRequest)
+					_ = json.NewEncoder(w).Encode(map[string]any{"error": "invalid_grant", "error_description": tt.description})
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"access_token":  "new-access",
+					"refresh_token": "rotated",
+					"token_type":    "Bearer",
+					"expires_in":    3600,
+				})
+			}))
+			defer tokenEndpoint.Close()
+
+			// The first refresh rotates the refresh token, which alone must not
+			// make the later rejection a reuse.
+			account := "rotating"
+			store := storage.NewMemoryStore()
+			cfg := &config.Config{
+				Accounts: map[string]*config.Account{
+					account: {TokenURI: tokenEndpoint.URL, TokenEndpointAuthMethod: config.AuthClientSecretPost},
+				},
+			}
+			token, err := refreshAccount(account, cfg, store, tokenEndpoint.Client(), &oauth2.Token{AccessToken: "old", RefreshToken: "refresh"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = refreshAccount(account, cfg, store, tokenEndpoint.Client(), token)
+			if kind := refreshErrorKindOf(err); kind != tt.want {
+				t.Fatalf("error kind = %q, want %q (err: %v)", kind, tt.want, err)
+			}
+			if _, err := store.Get(account); err == nil {
+				t.Fatal("rejected token was not deleted")
+			}
+		})
+	}
+}
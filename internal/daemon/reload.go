@@ -0,0 +1,40 @@
+package daemon
+
+import (
+	"log/slog"
+	"reflect"
+
+	"github.com/vybraan/vygrant/internal/logging"
+)
+
+// reloadConfig re-reads the config file on SIGHUP. The [log] section is applied
+// right away; other changes are reported and take effect after a restart. A
+// config_reloaded event carries the error when the file could not be loaded.
+func (d *Daemon) reloadConfig() {
+	cfg, err := LoadConfig()
+	if err != nil {
+		slog.Error("config reload failed", "path", ConfigPath(), "error", err)
+		events.publish(Event{Type: EventConfigReloaded, Error: err.Error()})
+		return
+	}
+
+	if !reflect.DeepEqual(cfg.Log, d.Config.Log) {
+		closer, err := logging.Setup(cfg.Log)
+		if err != nil {
+			slog.Error("config reload failed", "path", ConfigPath(), "error", err)
+			events.publish(Event{Type: EventConfigReloaded, Error: err.Error()})
+			return
+		}
+		d.logCloser.Close()
+		d.logCloser = closer
+		d.Config.Log = cfg.Log
+	}
+
+	pending := *cfg
+	pending.Log = d.Config.Log
+	if !reflect.DeepEqual(&pending, d.Config) {
+		slog.Warn("config changes other than [log] take effect after a restart", "path", ConfigPath())
+	}
+	slog.Info("config reloaded", "path", ConfigPath())
+	events.publish(Event{Type: EventConfigReloaded})
+}
@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+func TestReloadConfigPublishesConfigReloaded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vygrant.toml")
+	t.Setenv("VYGRANT_CONFIG", path)
+	if err := os.WriteFile(path, []byte("browser = \"firefox\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := &Daemon{Config: &config.Config{}}
+	ch, cancel := events.subscribe()
+	defer cancel()
+
+	next := func() Event {
+		t.Helper()
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no config_reloaded event")
+		}
+		return Event{}
+	}
+
+	d.reloadConfig()
+	if e := next(); e.Type != EventConfigReloaded || e.Error != "" {
+		t.Errorf("event = %+v, want config_reloaded without error", e)
+	}
+
+	if err := os.WriteFile(path, []byte("browser = [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d.reloadConfig()
+	if e := next(); e.Type != EventConfigReloaded || e.Error == "" {
+		t.Errorf("event = %+v, want config_reloaded with the load error", e)
+	}
+}
@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+// scopedToken returns an access token for the scope set of key ("account#set").
+// It is cached in store under key and requested with the refresh token stored for
+// the account when it is missing, has expired or force is set. The request holds
+// the account's refreshTokenLocks entry, like the account's own refreshes, and a
+// refresh token rotated by it is saved back to the account.
+//
+// A rejection only drops the cached token: providers also answer invalid_grant when
+// the user has not consented to the scopes of one set, and the account's refresh
+// token then still works for the others.
+func scopedToken(key string, cfg *config.Config, store storage.TokenStore, httpClient *http.Client, force bool) (*oauth2.Token, error) {
+	cachedToken := func() *oauth2.Token {
+		if cached, err := store.Get(key); err == nil && cached.Valid() {
+			return cached
+		}
+		return nil
+	}
+	if cached := cachedToken(); cached != nil && !force {
+		return cached, nil
+	}
+
+	base, _ := config.SplitScopeKey(key)
+	return refreshFlights.do(key, func() (*oauth2.Token, error) {
+		if cached := cachedToken(); cached != nil && !force {
+			return cached, nil
+		}
+		unlock := refreshTokenLocks.lock(base)
+		defer unlock()
+		token, err := store.Get(base)
+		if err != nil {
+			return nil, err
+		}
+		if token.RefreshToken == "" {
+			return nil, fmt.Errorf("%w for '%s'", errNoRefreshToken, base)
+		}
+
+		newToken, err := refreshWithRetry(key, cfg, token, httpClient)
+		if err != nil {
+			err = classifyRejection(err)
+		}
+		refreshStates.record(key, err)
+		publishRefresh(key, newToken, err)
+		if err != nil {
+			if isRefreshRejected(err) {
+				if err := store.Delete(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+					slog.Error("failed to delete rejected scope set token", "account", key, "error", err)
+				}
+			}
+			return nil, err
+		}
+
+		if newToken.RefreshToken != "" && newToken.RefreshToken != token.RefreshToken {
+			refreshStates.rotated(base)
+			slog.Debug("refresh token rotated", "account", base, "scope_set", key)
+			saveRotatedRefreshToken(base, store, token.RefreshToken, newToken.RefreshToken)
+		}
+		cached := &oauth2.Token{AccessToken: newToken.AccessToken, TokenType: newToken.TokenType, Expiry: newToken.Expiry}
+		if err := store.Set(key, cached); err != nil {
+			slog.Error("failed to save scope set token", "account", key, "error", err)
+		}
+		return cached, nil
+	})
+}
+
+// saveRotatedRefreshToken replaces the refresh token of account, spent as old, with
+// rotated. The stored token is read again so its current access token is kept; when
+// a login replaced the refresh token meanwhile, the new login wins.
+func saveRotatedRefreshToken(account string, store storage.TokenStore, old, rotated string) {
+	current, err := store.Get(account)
+	if err != nil {
+		slog.Warn("account token disappeared before its rotated refresh token was saved", "account", account, "error", err)
+		return
+	}
+	if current.RefreshToken != old {
+		slog.Info("refresh token changed during a scope set refresh; keeping the new one", "account", account)
+		return
+	}
+	updated := *current
+	updated.RefreshToken = rotated
+	if err := store.Set(account, &updated); err != nil {
+		slog.Error("failed to save rotated refresh token", "account", account, "error", err)
+	}
+}
+
+// needsLogin reports whether err means that only a new authorization of the
+// account can produce a token.
+func needsLogin(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, errNoRefreshToken) || isRefreshRejected(err)
+}
+
+// writeDerivedToken serves get-token for the key of a scope set, a token exchange
+// account or a jwt_bearer account. When the account they derive from needs a new
+// authorization it waits for a login like get-token does for accounts.
+func (d *Daemon) writeDerivedToken(conn net.Conn, key string, wait time.Duration) {
+	if _, ok := d.Config.LookupAccount(key); !ok {
+		writeError(conn, "Could not retrieve token for '%s': %v", key, ErrAccountNotFound)
+		return
+	}
+	token, err := awaitRefresh(socketRefreshWait, func() (*oauth2.Token, error) {
+		return d.accessToken(key, false)
+	})
+	if err != nil && needsLogin(err) && d.hasLogin(key) {
+		if wait > 0 {
+			d.writeTokenAfterLogin(conn, key, wait)
+			return
+		}
+		if token, ok := d.autoLogin(conn, key); ok {
+			writeResponse(conn, "%s", token.AccessToken)
+			return
+		}
+		writeError(conn, "Could not retrieve token for '%s': %v. Please authenticate at: %s", key, err, d.authURL(key))
+		return
+	}
+	if err != nil {
+		writeError(conn, "Could not retrieve token for '%s' (%s): %v", key, refreshErrorKindOf(err), err)
+		return
+	}
+	writeResponse(conn, "%s", token.AccessToken)
+}
+
+// dropScopeTokens is the storage.ChangeFunc that deletes the cached scope set
+// tokens of an account whose token was deleted, since they were issued for it.
+func dropScopeTokens(cfg *config.Config, store storage.TokenStore) storage.ChangeFunc {
+	return func(account, event string) {
+		if event != "delete" {
+			return
+		}
+		acct, ok := cfg.LookupAccount(account)
+		if _, set := config.SplitScopeKey(account); !ok || set != "" {
+			return
+		}
+		for set := range acct.Scopes.Sets {
+			key := account + config.ScopeSeparator + set
+			if err := store.Delete(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("failed to delete scope set token", "account", key, "error", err)
+			}
+		}
+	}
+}
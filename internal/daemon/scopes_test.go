@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func TestScopeSetTokenUsesAccountRefreshToken(t *testing.T) {
+	var requests atomic.Int32
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		requests.Add(1)
+		if r.Form.Get("refresh_token") != "refresh" || r.Form.Get("scope") != "Graph.Read offline_access" {
+			t.Errorf("unexpected refresh request: %v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "graph-access",
+			"refresh_token": "rotated",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+	}))
+	defer tokenEndpoint.Close()
+
+	store := storage.NewMemoryStore()
+	if err := store.Set("acct", &oauth2.Token{
+		AccessToken:  "imap-access",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Accounts: map[string]*config.Account{
+			"acct": {TokenURI: tokenEndpoint.URL, Scopes: config.Scopes{Sets: map[string]config.ScopeSet{
+				"graph": {Scopes: []string{"Graph.Read", "offline_access"}},
+			}}},
+		},
+	}
+
+	for range 2 {
+		token, err := scopedToken("acct#graph", cfg, store, tokenEndpoint.Client(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.AccessToken != "graph-access" || token.RefreshToken != "" {
+			t.Fatalf("scope set token = %+v, want graph-access without refresh token", token)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("token endpoint called %d times, want 1", got)
+	}
+
+	account, err := store.Get("acct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.AccessToken != "imap-access" || account.RefreshToken != "rotated" {
+		t.Fatalf("account token = %+v, want imap-access with the rotated refresh token", account)
+	}
+}
+
+// rotatingEndpoint issues a new refresh token with every refresh and rejects a
+// refresh token that was already spent, like providers that detect reuse.
+type rotatingEndpoint struct {
+	mu      sync.Mutex
+	current string
+	issued  int
+	reused  []string
+}
+
+func (e *rotatingEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	spent := r.Form.Get("refresh_token")
+	// Widen the window in which two requests could spend the same token.
+	time.Sleep(5 * time.Millisecond)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if spent != e.current {
+		e.reused = append(e.reused, spent)
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": "invalid_grant", "error_description": "refresh token reused"})
+		return
+	}
+	e.issued++
+	e.current = fmt.Sprintf("refresh-%d", e.issued)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  fmt.Sprintf("access-%d", e.issued),
+		"refresh_token": e.current,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func TestScopeSetAndAccountRefreshesNeverSpendOneRefreshTokenTwice(t *testing.T) {
+	endpoint := &rotatingEndpoint{current: "refresh-0"}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	cfg := &config.Config{Accounts: map[string]*config.Account{
+		"rot": {
+			TokenURI:                server.URL,
+			TokenEndpointAuthMethod: config.AuthClientSecretPost,
+			Scopes: config.Scopes{Sets: map[string]config.ScopeSet{
+				"mail":     {Scopes: []string{"mail"}},
+				"calendar": {Scopes: []string{"calendar"}},
+			}},
+		},
+	}}
+	store := storage.NewMemoryStore()
+	if err := store.Set("rot", &oauth2.Token{AccessToken: "access-0", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	defer refreshStates.reset("rot")
+
+	var wg sync.WaitGroup
+	for i := range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			switch i % 3 {
+			case 0:
+				var token *oauth2.Token
+				if token, err = store.Get("rot"); err == nil {
+					_, err = refreshAccount("rot", cfg, store, server.Client(), token)
+				}
+			case 1:
+				_, err = scopedToken("rot#mail", cfg, store, server.Client(), true)
+			case 2:
+				_, err = scopedToken("rot#calendar", cfg, store, server.Client(), true)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	if len(endpoint.reused) != 0 {
+		t.Errorf("refresh tokens spent twice: %q", endpoint.reused)
+	}
+	token, err := store.Get("rot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.RefreshToken != endpoint.current {
+		t.Errorf("stored refresh token = %q, want the last issued %q", token.RefreshToken, endpoint.current)
+	}
+}
+
+func TestSaveRotatedRefreshTokenKeepsCurrentToken(t *testing.T) {
+	store := storage.NewMemoryStore()
+	store.Set("acct", &oauth2.Token{AccessToken: "refreshed-access", RefreshToken: "old"})
+	saveRotatedRefreshToken("acct", store, "old", "rotated")
+	if token, _ := store.Get("acct"); token.AccessToken != "refreshed-access" || token.RefreshToken != "rotated" {
+		t.Errorf("token = %+v, want the current access token with the rotated refresh token", token)
+	}
+
+	// A login replaced the refresh token while the scope set refresh ran.
+	store.Set("acct", &oauth2.Token{AccessToken: "login-access", RefreshToken: "login"})
+	saveRotatedRefreshToken("acct", store, "rotated", "rotated-again")
+	if token, _ := store.Get("acct"); token.RefreshToken != "login" {
+		t.Errorf("refresh token = %q, want the one from the login", token.RefreshToken)
+	}
+}
@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+// AccountStatus is the health of one account as reported by the status command.
+type AccountStatus struct {
+	Account        string     `json:"account"`
+	Identity       string     `json:"identity,omitempty"`
+	GrantType      string     `json:"grant_type"`
+	AccessToken    bool       `json:"access_token"`
+	Expiry         *time.Time `json:"expiry,omitempty"`
+	ExpiresIn      int64      `json:"expires_in,omitempty"`
+	RefreshToken   bool       `json:"refresh_token"`
+	RefreshBackend string     `json:"refresh_backend,omitempty"`
+	LastRefresh    *time.Time `json:"last_refresh,omitempty"`
+	LastResult     string     `json:"last_result,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Failures       int        `json:"consecutive_failures,omitempty"`
+	Healthy        bool       `json:"healthy"`
+	Problem        string     `json:"problem,omitempty"`
+}
+
+// accountStatuses returns the status of every configured token key sorted by name,
+// so identities follow their account. When account is set only its own keys are
+// included: the account and its identities, or the one identity it names.
+func (d *Daemon) accountStatuses(account string) ([]AccountStatus, error) {
+	var names []string
+	if account != "" {
+		if _, ok := d.Config.LookupAccount(account); !ok {
+			return nil, ErrAccountNotFound
+		}
+	}
+	for _, key := range d.Config.TokenKeys() {
+		if account == "" || config.KeyBelongsTo(key, account) {
+			names = append(names, key)
+		}
+	}
+
+	statuses := make([]AccountStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, d.accountStatus(name))
+	}
+	return statuses, nil
+}
+
+func (d *Daemon) accountStatus(name string) AccountStatus {
+	now := time.Now()
+	acct, _ := d.Config.LookupAccount(name)
+	_, identity := config.SplitTokenKey(name)
+	status := AccountStatus{
+		Account:   name,
+		Identity:  identity,
+		GrantType: acct.GrantType(),
+	}
+
+	token, err := d.TokenStore.Get(name)
+	if err == nil && token != nil {
+		status.AccessToken = token.AccessToken != ""
+		if status.AccessToken && !token.Expiry.IsZero() {
+			expiry := token.Expiry
+			status.Expiry = &expiry
+			status.ExpiresIn = int64(expiry.Sub(now).Seconds())
+		}
+		status.RefreshToken = token.RefreshToken != ""
+		if status.RefreshToken {
+			status.RefreshBackend = refreshBackend(d.TokenStore)
+		}
+	}
+
+	if state, ok := refreshStates.get(name); ok {
+		lastAttempt := state.LastAttempt
+		status.LastRefresh = &lastAttempt
+		status.LastResult = "ok"
+		if state.Failures > 0 {
+			status.LastResult = string(state.ErrorKind)
+			status.LastError = state.LastError
+			status.Failures = state.Failures
+		}
+	}
+
+	expired := status.Expiry != nil && !status.Expiry.After(now)
+	switch {
+	case err != nil || (!status.AccessToken && !status.RefreshToken):
+		status.Problem = "not authenticated"
+	case status.LastResult == string(refreshRotated):
+		status.Problem = "refresh token was rotated elsewhere"
+	case status.Failures > 0:
+		status.Problem = "refresh failing"
+	case expired && !status.RefreshToken:
+		status.Problem = "expired and no refresh token"
+	}
+	status.Healthy = status.Problem == ""
+	return status
+}
+
+// FormatStatus renders statuses as the human-readable status report. Identities are
+// indented below their account.
+func FormatStatus(statuses []AccountStatus) string {
+	if len(statuses) == 0 {
+		return "No accounts configured."
+	}
+	now := time.Now()
+	var b strings.Builder
+	for i, s := range statuses {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		health := "ok"
+		if !s.Healthy {
+			health = "UNHEALTHY: " + s.Problem
+		}
+		indent := "  "
+		if s.Identity != "" {
+			b.WriteString("  ")
+			indent = "    "
+		}
+		fmt.Fprintf(&b, "%s: %s\n", s.Account, health)
+		fmt.Fprintf(&b, "%sgrant type:    %s\n", indent, s.GrantType)
+
+		access := "missing"
+		if s.AccessToken {
+			access = "present"
+			if s.Expiry != nil {
+				if remaining := s.Expiry.Sub(now); remaining > 0 {
+					access += fmt.Sprintf(", expires in %s", remaining.Round(time.Second))
+				} else {
+					access += fmt.Sprintf(", expired %s ago", (-remaining).Round(time.Second))
+				}
+			}
+		}
+		fmt.Fprintf(&b, "%saccess token:  %s\n", indent, access)
+
+		refresh := "missing"
+		if s.RefreshToken {
+			refresh = "present (" + s.RefreshBackend + ")"
+		}
+		fmt.Fprintf(&b, "%srefresh token: %s\n", indent, refresh)
+
+		lastRefresh := "never"
+		if s.LastRefresh != nil {
+			lastRefresh = fmt.Sprintf("%s ago, %s", now.Sub(*s.LastRefresh).Round(time.Second), s.LastResult)
+			if s.Failures > 1 {
+				lastRefresh += fmt.Sprintf(" (%d consecutive failures)", s.Failures)
+			}
+		}
+		fmt.Fprintf(&b, "%slast refresh:  %s\n", indent, lastRefresh)
+		if s.LastError != "" {
+			fmt.Fprintf(&b, "%slast error:    %s\n", indent, s.LastError)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
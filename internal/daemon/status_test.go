@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+func TestAccountStatusClassification(t *testing.T) {
+	rejected := &refreshError{kind: refreshRejected, err: errors.New("invalid_grant")}
+	rotated := &refreshError{kind: refreshRotated, err: errors.New("invalid_grant: token reuse")}
+	transient := &refreshError{kind: refreshTransient, err: errors.New("503")}
+
+	tests := []struct {
+		name     string
+		token    *oauth2.Token
+		failures []error
+		healthy  bool
+		problem  string
+		result   string
+	}{
+		{name: "not authenticated", problem: "not authenticated"},
+		{name: "valid", token: &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: time.Now().Add(time.Hour)}, healthy: true},
+		{name: "expired with refresh token", token: &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: time.Now().Add(-time.Hour)}, healthy: true},
+		{name: "expired without refresh token", token: &oauth2.Token{AccessToken: "a", Expiry: time.Now().Add(-time.Hour)}, problem: "expired and no refresh token"},
+		{name: "refresh failing", token: &oauth2.Token{AccessToken: "a", RefreshToken: "r"}, failures: []error{transient, transient}, problem: "refresh failing", result: "transient"},
+		{name: "rejected", token: &oauth2.Token{AccessToken: "a", RefreshToken: "r"}, failures: []error{rejected}, problem: "refresh failing", result: "rejected"},
+		{name: "rotated elsewhere", token: &oauth2.Token{AccessToken: "a", RefreshToken: "r"}, failures: []error{rotated}, problem: "refresh token was rotated elsewhere", result: "rotated"},
+		{name: "recovered", token: &oauth2.Token{AccessToken: "a", RefreshToken: "r"}, failures: []error{transient, nil}, healthy: true, result: "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := "status-" + strings.ReplaceAll(tt.name, " ", "-")
+			refreshStates.reset(account)
+			defer refreshStates.reset(account)
+			store := storage.NewMemoryStore()
+			if tt.token != nil {
+				if err := store.Set(account, tt.token); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, err := range tt.failures {
+				refreshStates.record(account, err)
+			}
+			d := &Daemon{
+				Config:     &config.Config{Accounts: map[string]*config.Account{account: {}}},
+				TokenStore: store,
+			}
+
+			status := d.accountStatus(account)
+			if status.Healthy != tt.healthy || status.Problem != tt.problem {
+				t.Errorf("healthy = %v, problem = %q; want %v, %q", status.Healthy, status.Problem, tt.healthy, tt.problem)
+			}
+			if status.LastResult != tt.result {
+				t.Errorf("last result = %q, want %q", status.LastResult, tt.result)
+			}
+			if status.GrantType != config.GrantAuthorizationCode {
+				t.Errorf("grant type = %q", status.GrantType)
+			}
+		})
+	}
+}
+
+func TestAccountStatusesFiltersByAccount(t *testing.T) {
+	d := &Daemon{
+		Config: &config.Config{Accounts: map[string]*config.Account{
+			"work":  {Identities: map[string]*config.Identity{"alice": {}, "bob": {}}},
+			"works": {},
+		}},
+		TokenStore: storage.NewMemoryStore(),
+	}
+	tests := []struct {
+		account string
+		want    []string
+	}{
+		{"", []string{"work", "work/alice", "work/bob", "works"}},
+		{"work", []string{"work", "work/alice", "work/bob"}},
+		{"work/bob", []string{"work/bob"}},
+	}
+	for _, tt := range tests {
+		statuses, err := d.accountStatuses(tt.account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range statuses {
+			got = append(got, s.Account)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("accountStatuses(%q) = %v, want %v", tt.account, got, tt.want)
+		}
+	}
+	if _, err := d.accountStatuses("missing"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("unknown account: err = %v", err)
+	}
+}
+
+func TestFormatStatus(t *testing.T) {
+	lastRefresh := time.Now().Add(-time.Minute)
+	expiry := time.Now().Add(time.Hour)
+	got := FormatStatus([]AccountStatus{
+		{
+			Account: "work", GrantType: "authorization_code", Healthy: true,
+			AccessToken: true, Expiry: &expiry, RefreshToken: true, RefreshBackend: "keyring",
+		},
+		{
+			Account: "work/alice", Identity: "alice", GrantType: "authorization_code",
+			Problem: "refresh failing", RefreshToken: true, RefreshBackend: "keyring",
+			LastRefresh: &lastRefresh, LastResult: "transient", LastError: "503", Failures: 3,
+		},
+	})
+	for _, want := range []string{
+		"work: ok\n  grant type:    authorization_code\n  access token:  present, expires in ",
+		"  refresh token: present (keyring)\n  last refresh:  never\n",
+		"\n  work/alice: UNHEALTHY: refresh failing\n    grant type:    authorization_code\n    access token:  missing\n",
+		"    last refresh:  1m0s ago, transient (3 consecutive failures)\n    last error:    503",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("status report is missing %q:\n%s", want, got)
+		}
+	}
+	if FormatStatus(nil) != "No accounts configured." {
+		t.Errorf("empty report = %q", FormatStatus(nil))
+	}
+}
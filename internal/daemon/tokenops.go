@@ -2,10 +2,15 @@ package daemon
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/vybraan/vygrant/internal/audit"
+	"github.com/vybraan/vygrant/internal/auth"
 	"github.com/vybraan/vygrant/internal/config"
 	"github.com/vybraan/vygrant/internal/storage"
 	"golang.org/x/oauth2"
@@ -13,56 +18,120 @@ import (
 
 // RefreshToken obtains a new OAuth2 token for the named account using the provided existing token.
 // If httpClient is non-nil it is attached to the refresh request context and used for HTTP calls.
-// It returns ErrAccountNotFound if the account is not present in cfg.Accounts, or any error produced by the token source when fetching the new token.
+// For the key of a scope set ("account#set") the token is requested for the scopes of that set.
+// A jwt_bearer account has no refresh token; its token is requested with a new assertion instead.
+// It returns ErrAccountNotFound if the account or identity is not configured, or any error produced by the token source when fetching the new token.
 func RefreshToken(account string, cfg *config.Config, oldToken *oauth2.Token, httpClient *http.Client) (*oauth2.Token, error) {
-	acct := cfg.Accounts[account]
-	if acct == nil {
+	acct, ok := cfg.LookupAccount(account)
+	if !ok || acct == nil {
 		return nil, ErrAccountNotFound
 	}
+	if acct.GrantType() == config.GrantJWTBearer {
+		return AssertionToken(account, cfg, httpClient)
+	}
 
 	oauthCfg := config.GetOAuth2Config(acct)
-	ctx := context.Background()
-	if httpClient != nil {
-		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	params := config.TokenParams(acct)
+	if _, set := config.SplitScopeKey(account); set != "" {
+		oauthCfg.Scopes = acct.Scopes.For(set)
+		params.Set("scope", strings.Join(oauthCfg.Scopes, " "))
+	}
+	httpClient, err := auth.TokenClient(acct, config.AccountHTTP(cfg.HTTP, acct), httpClient, params)
+	if err != nil {
+		return nil, err
 	}
-	ts := oauthCfg.TokenSource(ctx, oldToken)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	// Only the refresh token is passed on: the token source would hand back a still
+	// valid access token instead of refreshing it.
+	ts := oauthCfg.TokenSource(ctx, &oauth2.Token{RefreshToken: oldToken.RefreshToken})
+	start := time.Now()
 	newToken, err := ts.Token()
+	recordRefresh(account, err)
+	slog.Debug("token refresh request", "account", account, "duration", time.Since(start), "ok", err == nil)
 	if err != nil {
 		return nil, err
 	}
 	return newToken, nil
 }
 
-// checkExpiringTokens iterates configured accounts and refreshes tokens whose expiry is within expiryThreshold.
-// It skips accounts with no stored token or without a refresh token. For tokens needing refresh it calls
-// RefreshToken (using the provided httpClient when non-nil), updates tokenStore on success, and logs and
-// notifies on refresh failures.
-func checkExpiringTokens(cfg *config.Config, tokenStore storage.TokenStore, httpClient *http.Client) {
-	now := time.Now()
-	for account := range cfg.Accounts {
-		token, err := tokenStore.Get(account)
-		if err != nil || token == nil {
-			continue
-		}
+// errNoRefreshToken is returned when a token must be refreshed but has no refresh
+// token.
+var errNoRefreshToken = errors.New("no refresh token")
 
-		if token.RefreshToken == "" {
-			continue
+// accessToken returns a usable access token for account, refreshing it first when it
+// has expired or when force is set. Refreshed tokens are saved to the token store.
+func (d *Daemon) accessToken(account string, force bool) (*oauth2.Token, error) {
+	if _, set := config.SplitScopeKey(account); set != "" {
+		return scopedToken(account, d.Config, d.TokenStore, d.HTTPClient, force)
+	}
+	if isExchanged(d.Config, account) {
+		return d.exchangedToken(account, force)
+	}
+	assertion := isAssertion(d.Config, account)
+	token, err := d.TokenStore.Get(account)
+	if err != nil {
+		if !assertion {
+			return nil, err
 		}
+		token = &oauth2.Token{}
+	}
+	if !force && token.Valid() {
+		return token, nil
+	}
+	if token.RefreshToken == "" && !assertion {
+		return nil, fmt.Errorf("%w for '%s'", errNoRefreshToken, account)
+	}
 
-		if token.Expiry.Before(now.Add(expiryThreshold)) {
-			newToken, err := RefreshToken(account, cfg, token, httpClient)
-			if err != nil {
-				log.Printf("failed to auto-refresh token for %s: %v", account, err)
-				Notify("vygrant - auto refresh", "Token for "+account+" could not be refreshed.")
-				continue
-			}
+	return refreshAccount(account, d.Config, d.TokenStore, d.HTTPClient, token)
+}
 
-			if err := tokenStore.Set(account, newToken); err != nil {
-				log.Printf("failed to save refreshed token for %s: %v", account, err)
-			}
+// templateToken is the render.TokenFunc used by [[template]] blocks.
+func (d *Daemon) templateToken(account string) (string, error) {
+	token, err := d.auditedToken("template")(account, false)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
 
-			log.Printf("Token for %s refreshed. New expiry: %s", account, newToken.Expiry)
+// checkExpiringTokens iterates configured accounts and refreshes tokens that expire within their
+// refreshLead. It skips accounts with no stored token or without a refresh token. For tokens needing
+// refresh it calls refreshAccount (using the provided httpClient when non-nil), which updates tokenStore,
+// and logs and notifies on refresh failures. It returns the number of accounts whose refresh failed.
+func checkExpiringTokens(cfg *config.Config, tokenStore storage.TokenStore, httpClient *http.Client) int {
+	failed := 0
+	for _, account := range cfg.TokenKeys() {
+		if _, err := checkAccount(cfg, tokenStore, httpClient, account); err != nil {
+			failed++
 		}
+	}
+	return failed
+}
+
+// checkAccount refreshes the token of account if it expires within its refreshLead
+// and reports whether a new token was stored, or the refresh error.
+func checkAccount(cfg *config.Config, tokenStore storage.TokenStore, httpClient *http.Client, account string) (bool, error) {
+	token, err := tokenStore.Get(account)
+	if err != nil || token == nil {
+		return false, nil
+	}
 
+	if token.RefreshToken == "" && !isAssertion(cfg, account) {
+		return false, nil
 	}
+
+	if !token.Expiry.Before(time.Now().Add(refreshLead(cfg, account, token))) {
+		return false, nil
+	}
+
+	newToken, err := refreshAccount(account, cfg, tokenStore, httpClient, token)
+	recordAudit(auditResult(audit.Entry{Event: "refresh", Source: "background", Account: account}, err))
+	if err != nil {
+		slog.Warn("background refresh failed", "account", account, "kind", refreshErrorKindOf(err), "error", err)
+		notifyRefreshFailure(account, err)
+		return false, err
+	}
+
+	slog.Info("token refreshed", "account", account, "expiry", newToken.Expiry)
+	return true, nil
 }
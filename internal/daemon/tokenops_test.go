@@ -1,9 +1,20 @@
 package daemon
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,10 +26,14 @@ import (
 func TestCheckExpiringTokensRefreshesExpiredToken(t *testing.T) {
 	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseForm(); err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
 		}
 		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "refresh" {
-			t.Fatalf("unexpected refresh request: %v", r.Form)
+			t.Errorf("unexpected refresh request: %v", r.Form)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]any{
@@ -53,3 +68,111 @@ func TestCheckExpiringTokensRefreshesExpiredToken(t *testing.T) {
 		t.Fatalf("AccessToken = %q, want new-access", token.AccessToken)
 	}
 }
+
+func TestRefreshTokenSendsClientAssertion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "client.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if _, _, ok := r.BasicAuth(); ok || r.Form.Has("client_secret") {
+			t.Errorf("client secret sent with private_key_jwt: %v", r.Form)
+		}
+		if r.Form.Get("client_id") != "client" ||
+			r.Form.Get("client_assertion_type") != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+			t.Errorf("unexpected refresh request: %v", r.Form)
+		}
+		parts := strings.Split(r.Form.Get("client_assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("client_assertion is not a JWT: %q", r.Form.Get("client_assertion"))
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var claims map[string]any
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			t.Error(err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if claims["iss"] != "client" || claims["sub"] != "client" || claims["aud"] != "https://issuer.example" {
+			t.Errorf("unexpected assertion claims: %v", claims)
+		}
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil || len(signature) != 64 {
+			t.Errorf("bad ES256 signature: %v", err)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		r0, s0 := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(&key.PublicKey, digest[:], r0, s0) {
+			t.Error("client assertion signature does not verify")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "new", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenEndpoint.Close()
+
+	cfg := &config.Config{
+		Accounts: map[string]*config.Account{
+			"acct": {
+				TokenURI: tokenEndpoint.URL, ClientID: "client", ClientSecret: "secret",
+				TokenEndpointAuthMethod: config.AuthPrivateKeyJWT,
+				ClientAssertionKey:      keyFile,
+				ClientAssertionAudience: "https://issuer.example",
+			},
+		},
+	}
+	token, err := RefreshToken("acct", cfg, &oauth2.Token{RefreshToken: "refresh"}, tokenEndpoint.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "new" {
+		t.Fatalf("access token = %q, want new", token.AccessToken)
+	}
+}
+
+func TestRefreshTokenUsesAccountHTTPSettings(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "idp.invalid" || r.UserAgent() != "vygrant-test" {
+			t.Errorf("unexpected proxied request to %s with User-Agent %q", r.URL, r.UserAgent())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "new", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer proxy.Close()
+
+	cfg := &config.Config{
+		HTTP: config.HTTP{UserAgent: "vygrant-test"},
+		Accounts: map[string]*config.Account{
+			"acct": {TokenURI: "http://idp.invalid/token", HTTP: config.HTTP{Proxy: proxy.URL}},
+		},
+	}
+	token, err := RefreshToken("acct", cfg, &oauth2.Token{RefreshToken: "refresh"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "new" {
+		t.Fatalf("access token = %q, want new", token.AccessToken)
+	}
+}
@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	// clockCheckInterval is how often the wall clock is compared with the monotonic
+	// clock. The monotonic clock stops while the machine is suspended.
+	clockCheckInterval = 15 * time.Second
+	// clockJumpThreshold is the drift between both clocks reported as a resume.
+	clockJumpThreshold = 30 * time.Second
+	// networkEventQuiet is the minimum time between two network-up passes.
+	networkEventQuiet = 30 * time.Second
+
+	wakeRetries   = 5
+	wakeRetryBase = 2 * time.Second
+)
+
+// signalWake queues reason on wake unless a wake-up is already pending.
+func signalWake(wake chan<- string, reason string) {
+	select {
+	case wake <- reason:
+	default:
+	}
+}
+
+// clockFunc reads the wall clock and the time elapsed on the monotonic clock, which
+// stops while the machine is suspended.
+type clockFunc func() (wall time.Time, monotonic time.Duration)
+
+// systemClock reads both clocks of the system, measuring monotonic time from its
+// creation.
+func systemClock() clockFunc {
+	start := time.Now()
+	return func() (time.Time, time.Duration) {
+		now := time.Now()
+		return now.Round(0), now.Sub(start)
+	}
+}
+
+// watchClock signals wake when the wall clock moved noticeably further than the
+// monotonic clock between two checks, which happens after a suspend or when the
+// clock is set.
+func watchClock(wake chan<- string, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(clockCheckInterval)
+	defer ticker.Stop()
+	detectClockJumps(wake, stopCh, ticker.C, systemClock())
+}
+
+// detectClockJumps compares both clocks of read on every tick until stopCh is closed.
+func detectClockJumps(wake chan<- string, stopCh <-chan struct{}, ticks <-chan time.Time, read clockFunc) {
+	lastWall, lastMonotonic := read()
+	for {
+		select {
+		case <-ticks:
+			now, elapsed := read()
+			monotonic := elapsed - lastMonotonic
+			wall := now.Sub(lastWall)
+			lastWall, lastMonotonic = now, elapsed
+			if drift := wall - monotonic; drift > clockJumpThreshold || drift < -clockJumpThreshold {
+				slog.Info("wall clock jump detected", "drift", drift.Round(time.Second))
+				signalWake(wake, "clock jump")
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// wakeUp runs checkExpiringTokens after a resume or network change and retries with
+// exponential backoff while refreshes fail, since the network is often not usable
+// right away. Timers are rescheduled after every pass because they were delayed by
+// the suspend.
+func (s *scheduler) wakeUp(reason string, stopCh <-chan struct{}) {
+	slog.Info("checking tokens after wake-up", "reason", reason)
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		failed := checkExpiringTokens(s.cfg, s.store, s.httpClient)
+		backgroundCheckDuration.Observe(time.Since(start).Seconds())
+		s.scheduleAll()
+		if failed == 0 || attempt+1 >= wakeRetries {
+			return
+		}
+		delay := wakeRetryBase << attempt
+		slog.Debug("retrying wake-up token check", "reason", reason, "failed", failed, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build linux
+
+package daemon
+
+import (
+	"log/slog"
+	"syscall"
+	"time"
+)
+
+// rtnetlink multicast groups for address changes (linux/rtnetlink.h); the syscall
+// package does not define them.
+const (
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// watchNetwork signals wake when an interface gains an address, as reported by the
+// kernel over an rtnetlink socket. Events closer than networkEventQuiet are merged.
+func watchNetwork(wake chan<- string, stopCh <-chan struct{}) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		slog.Warn("network change detection unavailable", "error", err)
+		return
+	}
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		slog.Warn("network change detection unavailable", "error", err)
+		return
+	}
+	defer syscall.Close(fd)
+	// Netlink sockets cannot be shut down, so reads time out to notice stopCh.
+	timeout := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		slog.Warn("network change detection unavailable", "error", err)
+		return
+	}
+
+	buf := make([]byte, 16*1024)
+	var last time.Time
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR || err == syscall.ENOBUFS {
+				continue
+			}
+			slog.Warn("network change detection stopped", "error", err)
+			return
+		}
+		if n == 0 || !hasNewAddress(buf[:n]) {
+			continue
+		}
+		if time.Since(last) < networkEventQuiet {
+			continue
+		}
+		last = time.Now()
+		slog.Info("network address added")
+		signalWake(wake, "network up")
+	}
+}
+
+func hasNewAddress(data []byte) bool {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return false
+	}
+	for _, msg := range msgs {
+		if msg.Header.Type == syscall.RTM_NEWADDR {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package daemon
+
+// watchNetwork is not supported on this platform; resumes are still detected by
+// watchClock.
+func watchNetwork(wake chan<- string, stopCh <-chan struct{}) {}
@@ -0,0 +1,136 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+	"github.com/vybraan/vygrant/internal/storage"
+	"golang.org/x/oauth2"
+)
+
+// fakeClock is a clockFunc whose wall and monotonic clocks the test advances.
+type fakeClock struct {
+	mu        sync.Mutex
+	wall      time.Time
+	monotonic time.Duration
+}
+
+func (c *fakeClock) read() (time.Time, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wall, c.monotonic
+}
+
+// advance moves the wall clock by wall and the monotonic clock by monotonic.
+func (c *fakeClock) advance(wall, monotonic time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(wall)
+	c.monotonic += monotonic
+}
+
+func TestDetectClockJumps(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	ticks := make(chan time.Time)
+	wake := make(chan string, 1)
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		detectClockJumps(wake, stopCh, ticks, clock.read)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+
+	tests := []struct {
+		name            string
+		wall, monotonic time.Duration
+		wake            bool
+	}{
+		{"steady", clockCheckInterval, clockCheckInterval, false},
+		{"small drift", clockCheckInterval + 10*time.Second, clockCheckInterval, false},
+		{"suspend", 2 * time.Hour, clockCheckInterval, true},
+		{"clock set back", clockCheckInterval - time.Minute, clockCheckInterval, true},
+	}
+	for _, tt := range tests {
+		clock.advance(tt.wall, tt.monotonic)
+		ticks <- time.Time{}
+		// The next tick is only received once this one has been handled.
+		clock.advance(clockCheckInterval, clockCheckInterval)
+		ticks <- time.Time{}
+
+		select {
+		case reason := <-wake:
+			if !tt.wake {
+				t.Errorf("%s: unexpected wake-up %q", tt.name, reason)
+			} else if reason != "clock jump" {
+				t.Errorf("%s: reason = %q, want clock jump", tt.name, reason)
+			}
+		default:
+			if tt.wake {
+				t.Errorf("%s: no wake-up", tt.name)
+			}
+		}
+	}
+}
+
+func TestSignalWakeDoesNotBlock(t *testing.T) {
+	wake := make(chan string, 1)
+	signalWake(wake, "clock jump")
+	signalWake(wake, "network up")
+	if reason := <-wake; reason != "clock jump" {
+		t.Errorf("reason = %q, want the first pending wake-up", reason)
+	}
+}
+
+func TestWakeUpRefreshesTokensDelayedBySuspend(t *testing.T) {
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "new-access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer tokenEndpoint.Close()
+
+	// The timer of this token should have fired during the suspend.
+	store := storage.NewMemoryStore()
+	if err := store.Set("suspended", &oauth2.Token{
+		AccessToken:  "old",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Accounts: map[string]*config.Account{"suspended": {TokenURI: tokenEndpoint.URL}}}
+	s := newScheduler(cfg, store, tokenEndpoint.Client())
+	defer func() {
+		s.mu.Lock()
+		s.stopped = true
+		for _, timer := range s.timers {
+			timer.Stop()
+		}
+		s.mu.Unlock()
+	}()
+
+	s.wakeUp("clock jump", make(chan struct{}))
+
+	token, err := store.Get("suspended")
+	if err != nil || token.AccessToken != "new-access" {
+		t.Fatalf("token = %v, %v; want it refreshed by the wake-up", token, err)
+	}
+	s.mu.Lock()
+	_, scheduled := s.timers["suspended"]
+	s.mu.Unlock()
+	if !scheduled {
+		t.Error("account was not rescheduled after the wake-up")
+	}
+}
@@ -0,0 +1,179 @@
+// Package hooks runs the configured [[hook]] commands for daemon events.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+const (
+	// QueueSize bounds the hook runs waiting for a worker; further runs are dropped.
+	QueueSize = 100
+
+	workers        = 4
+	defaultTimeout = 10 * time.Second
+	retryBase      = time.Second
+	maxStderr      = 512
+)
+
+// Payload is the JSON document written to a hook's stdin.
+type Payload struct {
+	Event       string     `json:"event"`
+	Account     string     `json:"account,omitempty"`
+	Time        time.Time  `json:"time"`
+	Expiry      *time.Time `json:"expiry,omitempty"`
+	Scopes      []string   `json:"scopes,omitempty"`
+	Backend     string     `json:"backend,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	Kind        string     `json:"kind,omitempty"`
+	AccessToken string     `json:"access_token,omitempty"`
+}
+
+// retrySleep waits between attempts of a failed hook; tests replace it.
+var retrySleep = time.Sleep
+
+// TokenFunc returns the stored access token of account.
+type TokenFunc func(account string) (string, error)
+
+type job struct {
+	hook    config.Hook
+	payload Payload
+}
+
+// Runner matches payloads against the configured hooks and runs the matching
+// commands on a small pool of workers, so slow hooks never block the daemon.
+type Runner struct {
+	hooks []config.Hook
+	token TokenFunc
+	queue chan job
+	wg    sync.WaitGroup
+}
+
+// New starts the workers for hooks. Call Close to stop them.
+func New(hooks []config.Hook, token TokenFunc) *Runner {
+	return newRunner(hooks, token, workers)
+}
+
+// NewOrdered is New with a single worker, so the hooks run one at a time in the
+// order their events were dispatched.
+func NewOrdered(hooks []config.Hook, token TokenFunc) *Runner {
+	return newRunner(hooks, token, 1)
+}
+
+func newRunner(hooks []config.Hook, token TokenFunc, workers int) *Runner {
+	r := &Runner{hooks: hooks, token: token, queue: make(chan job, QueueSize)}
+	for range workers {
+		r.wg.Add(1)
+		go r.work()
+	}
+	return r
+}
+
+// Dispatch queues every hook that matches p. Events for every account ("*") match
+// all account filters, and an account filter also matches the account's identities.
+// When the queue is full the run is dropped and logged.
+func (r *Runner) Dispatch(p Payload) {
+	for _, hook := range r.hooks {
+		if !matches(hook.Events, p.Event) || (p.Account != "*" && !matchesAccount(hook.Accounts, p.Account)) {
+			continue
+		}
+		select {
+		case r.queue <- job{hook: hook, payload: p}:
+		default:
+			slog.Warn("hook queue full; dropping hook run", "event", p.Event, "account", p.Account, "command", hook.Command)
+		}
+	}
+}
+
+// Close stops accepting runs and waits for the queued ones to finish.
+func (r *Runner) Close() {
+	close(r.queue)
+	r.wg.Wait()
+}
+
+func matches(list []string, value string) bool {
+	return len(list) == 0 || slices.Contains(list, value)
+}
+
+// matchesAccount is matches for account filters, where an account also matches its
+// identities.
+func matchesAccount(accounts []string, key string) bool {
+	return len(accounts) == 0 || slices.ContainsFunc(accounts, func(account string) bool {
+		return config.KeyBelongsTo(key, account)
+	})
+}
+
+func (r *Runner) work() {
+	defer r.wg.Done()
+	for j := range r.queue {
+		r.run(j)
+	}
+}
+
+// run executes the hook of j, retrying with exponential backoff when it fails.
+func (r *Runner) run(j job) {
+	payload := j.payload
+	if j.hook.IncludeToken && payload.Account != "" && payload.Account != "*" {
+		token, err := r.token(payload.Account)
+		if err != nil {
+			slog.Debug("hook token unavailable", "account", payload.Account, "error", err)
+		}
+		payload.AccessToken = token
+	}
+	input, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("hook payload encoding failed", "event", payload.Event, "error", err)
+		return
+	}
+
+	for attempt := 0; attempt <= j.hook.Retries; attempt++ {
+		if attempt > 0 {
+			retrySleep(retryBase << (attempt - 1))
+		}
+		start := time.Now()
+		stderr, err := execute(j.hook, payload, input)
+		if err == nil {
+			slog.Debug("hook finished", "event", payload.Event, "account", payload.Account, "command", j.hook.Command, "duration", time.Since(start))
+			return
+		}
+		slog.Warn("hook failed", "event", payload.Event, "account", payload.Account, "command", j.hook.Command,
+			"attempt", attempt+1, "error", err, "stderr", stderr)
+	}
+}
+
+func execute(hook config.Hook, p Payload, input []byte) (string, error) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	// A child left running by a killed shell would otherwise hold stderr open and
+	// keep the worker waiting past the timeout.
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"VYGRANT_EVENT="+p.Event,
+		"VYGRANT_ACCOUNT="+p.Account,
+	)
+	err := cmd.Run()
+	out := strings.TrimSpace(stderr.String())
+	if len(out) > maxStderr {
+		out = out[:maxStderr] + "..."
+	}
+	return out, err
+}
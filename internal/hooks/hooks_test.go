@@ -0,0 +1,165 @@
+package hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+// queued returns a Runner without workers, so dispatched runs stay in its queue.
+func queued(hooks ...config.Hook) *Runner {
+	return &Runner{hooks: hooks, queue: make(chan job, QueueSize)}
+}
+
+func TestDispatchMatchesEventsAndAccounts(t *testing.T) {
+	r := queued(
+		config.Hook{Command: "all"},
+		config.Hook{Command: "failures", Events: []string{"refresh_failed"}},
+		config.Hook{Command: "work", Accounts: []string{"work"}},
+		config.Hook{Command: "work-failures", Events: []string{"refresh_failed"}, Accounts: []string{"work", "home"}},
+	)
+	tests := []struct {
+		event   string
+		account string
+		want    []string
+	}{
+		{"refresh_ok", "work", []string{"all", "work"}},
+		{"refresh_failed", "work", []string{"all", "failures", "work", "work-failures"}},
+		{"refresh_failed", "work/alice", []string{"all", "failures", "work", "work-failures"}},
+		{"refresh_failed", "work#mail", []string{"all", "failures", "work", "work-failures"}},
+		{"refresh_failed", "works", []string{"all", "failures"}},
+		{"refresh_failed", "home", []string{"all", "failures", "work-failures"}},
+		{"token_set", "*", []string{"all", "work"}},
+	}
+	for _, tt := range tests {
+		r.Dispatch(Payload{Event: tt.event, Account: tt.account})
+		var got []string
+		for len(r.queue) > 0 {
+			got = append(got, (<-r.queue).hook.Command)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s %s ran %q, want %q", tt.event, tt.account, got, tt.want)
+		}
+	}
+}
+
+func TestDispatchDropsRunsWhenQueueIsFull(t *testing.T) {
+	r := queued(config.Hook{Command: "true"})
+	for i := 0; i < QueueSize+5; i++ {
+		r.Dispatch(Payload{Event: "refresh_ok", Account: "work"})
+	}
+	if len(r.queue) != QueueSize {
+		t.Errorf("queued runs = %d, want %d", len(r.queue), QueueSize)
+	}
+}
+
+func TestOrderedRunnerKeepsDispatchOrder(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "order")
+	// Earlier runs sleep longer, so parallel workers would finish out of order.
+	r := NewOrdered([]config.Hook{{Command: `sleep 0.0$((5 - VYGRANT_ACCOUNT)); echo "$VYGRANT_ACCOUNT" >> ` + out}}, nil)
+	for i := range 5 {
+		r.Dispatch(Payload{Event: "token_set", Account: string(rune('0' + i))})
+	}
+	r.Close()
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); !slices.Equal(got, []string{"0", "1", "2", "3", "4"}) {
+		t.Errorf("runs finished in order %q, want dispatch order", got)
+	}
+}
+
+func TestHookReceivesPayloadAndEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	command := `cat > "` + dir + `/$VYGRANT_EVENT-$VYGRANT_ACCOUNT.json"`
+	token := func(account string) (string, error) {
+		if account == "work" {
+			return "access-" + account, nil
+		}
+		return "", errors.New("no token")
+	}
+	r := New([]config.Hook{{Command: command, IncludeToken: true}}, token)
+	expiry := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	r.Dispatch(Payload{Event: "refresh_ok", Account: "work", Expiry: &expiry, Scopes: []string{"mail"}, Backend: "keyring"})
+	r.Dispatch(Payload{Event: "token_set", Account: "*"})
+	r.Dispatch(Payload{Event: "refresh_failed", Account: "home", Reason: "invalid_grant", Kind: "rejected"})
+	r.Close()
+
+	tests := []struct {
+		file string
+		want Payload
+	}{
+		{"refresh_ok-work.json", Payload{Event: "refresh_ok", Account: "work", Expiry: &expiry, Scopes: []string{"mail"}, Backend: "keyring", AccessToken: "access-work"}},
+		{"token_set-*.json", Payload{Event: "token_set", Account: "*"}},
+		{"refresh_failed-home.json", Payload{Event: "refresh_failed", Account: "home", Reason: "invalid_grant", Kind: "rejected"}},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Errorf("hook did not run with the expected environment: %v", err)
+			continue
+		}
+		var got Payload
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("invalid payload %q: %v", data, err)
+		}
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(tt.want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("%s payload = %s, want %s", tt.file, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestFailedHookIsRetried(t *testing.T) {
+	var delays []time.Duration
+	retrySleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { retrySleep = time.Sleep }()
+
+	runs := filepath.Join(t.TempDir(), "runs")
+	tests := []struct {
+		name    string
+		command string
+		retries int
+		want    int
+	}{
+		{"failing", `echo run >> ` + runs + `; exit 1`, 2, 3},
+		{"succeeds second time", `echo run >> ` + runs + `; [ $(wc -l < ` + runs + `) -ge 2 ]`, 3, 2},
+		{"no retries", `echo run >> ` + runs + `; exit 1`, 0, 1},
+	}
+	for _, tt := range tests {
+		os.Remove(runs)
+		delays = nil
+		r := &Runner{}
+		r.run(job{hook: config.Hook{Command: tt.command, Retries: tt.retries}, payload: Payload{Event: "refresh_ok"}})
+
+		data, _ := os.ReadFile(runs)
+		if got := strings.Count(string(data), "run\n"); got != tt.want {
+			t.Errorf("%s: runs = %d, want %d", tt.name, got, tt.want)
+		}
+		if len(delays) != tt.want-1 {
+			t.Errorf("%s: backoffs = %v, want %d", tt.name, delays, tt.want-1)
+		}
+		for i, d := range delays {
+			if d != retryBase<<i {
+				t.Errorf("%s: backoff %d = %v, want %v", tt.name, i, d, retryBase<<i)
+			}
+		}
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := execute(config.Hook{Command: "sleep 5", Timeout: 50 * time.Millisecond}, Payload{}, nil)
+	if err == nil || time.Since(start) > 4*time.Second {
+		t.Errorf("execute = %v after %v, want a timeout", err, time.Since(start))
+	}
+}
@@ -0,0 +1,221 @@
+// Package jwt signs the JSON Web Tokens used as OAuth2 client assertions
+// (private_key_jwt) and authorization grants (RFC 7523).
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Lifetime is how long a signed assertion is valid.
+const Lifetime = 5 * time.Minute
+
+// LoadKey reads a PEM encoded RSA, ECDSA (P-256, P-384) or Ed25519 private key in
+// PKCS #8, PKCS #1 or SEC 1 form.
+func LoadKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKey(path, data)
+}
+
+// KeyFile is a private key read by LoadKeyFile, with what a service account JSON key
+// says about it.
+type KeyFile struct {
+	Signer   crypto.Signer
+	KeyID    string
+	Issuer   string
+	TokenURI string
+}
+
+// LoadKeyFile reads a private key like LoadKey, or a Google style service account
+// JSON key, whose private_key_id, client_email and token_uri fill KeyID, Issuer and
+// TokenURI.
+func LoadKeyFile(path string) (*KeyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		signer, err := parseKey(path, data)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyFile{Signer: signer}, nil
+	}
+
+	var account struct {
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		ClientEmail  string `json:"client_email"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signer, err := parseKey(path, []byte(account.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return &KeyFile{
+		Signer:   signer,
+		KeyID:    account.PrivateKeyID,
+		Issuer:   account.ClientEmail,
+		TokenURI: account.TokenURI,
+	}, nil
+}
+
+func parseKey(path string, data []byte) (crypto.Signer, error) {
+	var err error
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, key)
+	}
+	if _, err := algorithm(signer); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// Claims are the claims of an assertion. Audience may hold one or more values.
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Scope    string
+}
+
+// Sign returns a compact JWT with claims, signed by key, issued now and expiring
+// after Lifetime, with a random jti. keyID is sent as the kid header when set.
+func Sign(key crypto.Signer, keyID string, claims Claims) (string, error) {
+	alg, err := algorithm(key)
+	if err != nil {
+		return "", err
+	}
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	payload := map[string]any{
+		"iss": claims.Issuer,
+		"sub": claims.Subject,
+		"jti": hex.EncodeToString(id),
+		"iat": now.Unix(),
+		"exp": now.Add(Lifetime).Unix(),
+	}
+	if len(claims.Audience) == 1 {
+		payload["aud"] = claims.Audience[0]
+	} else if len(claims.Audience) > 1 {
+		payload["aud"] = claims.Audience
+	}
+	if claims.Scope != "" {
+		payload["scope"] = claims.Scope
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encode(headerJSON) + "." + encode(payloadJSON)
+	signature, err := sign(key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + encode(signature), nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// algorithm returns the JWS algorithm used for key.
+func algorithm(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		}
+		return "", errors.New("unsupported elliptic curve; use P-256 or P-384")
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", key.Public())
+}
+
+func sign(key crypto.Signer, input []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var digest []byte
+		if k.Curve == elliptic.P384() {
+			sum := sha512.Sum384(input)
+			digest = sum[:]
+		} else {
+			sum := sha256.Sum256(input)
+			digest = sum[:]
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed-size concatenation of r and s, not ASN.1.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature := make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+		return signature, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, input), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
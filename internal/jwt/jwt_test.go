@@ -0,0 +1,234 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func pkcs8(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// verify checks the signature of token with pub and returns its header and claims.
+func verify(t *testing.T, token string, pub crypto.PublicKey) (map[string]any, map[string]any) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts", len(parts))
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(input)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			t.Fatalf("RS256 signature: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			t.Fatalf("ECDSA signature is %d bytes, want %d", len(signature), 2*size)
+		}
+		var digest []byte
+		if pub.Curve == elliptic.P384() {
+			sum := sha512.Sum384(input)
+			digest = sum[:]
+		} else {
+			sum := sha256.Sum256(input)
+			digest = sum[:]
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			t.Fatal("ECDSA signature does not verify")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, input, signature) {
+			t.Fatal("EdDSA signature does not verify")
+		}
+	default:
+		t.Fatalf("unexpected key %T", pub)
+	}
+
+	var header, claims map[string]any
+	for i, v := range []*map[string]any{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return header, claims
+}
+
+func TestLoadKeyAndSign(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	sec1, err := x509.MarshalECPrivateKey(p384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		blockType string
+		der       []byte
+		pub       crypto.PublicKey
+		alg       string
+	}{
+		{"RSA PKCS1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), &rsaKey.PublicKey, "RS256"},
+		{"RSA PKCS8", "PRIVATE KEY", pkcs8(t, rsaKey), &rsaKey.PublicKey, "RS256"},
+		{"P-256 PKCS8", "PRIVATE KEY", pkcs8(t, p256), &p256.PublicKey, "ES256"},
+		{"P-384 SEC1", "EC PRIVATE KEY", sec1, &p384.PublicKey, "ES384"},
+		{"Ed25519 PKCS8", "PRIVATE KEY", pkcs8(t, edKey), edKey.Public(), "EdDSA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := LoadKey(writePEM(t, tt.blockType, tt.der))
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := Sign(key, "kid-1", Claims{Issuer: "iss", Subject: "sub", Audience: []string{"https://token.example.com"}, Scope: "a b"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, claims := verify(t, token, tt.pub)
+			if header["alg"] != tt.alg || header["kid"] != "kid-1" || header["typ"] != "JWT" {
+				t.Errorf("header = %v, want alg %s", header, tt.alg)
+			}
+			if claims["iss"] != "iss" || claims["sub"] != "sub" || claims["aud"] != "https://token.example.com" || claims["scope"] != "a b" {
+				t.Errorf("claims = %v", claims)
+			}
+			iat, exp := claims["iat"].(float64), claims["exp"].(float64)
+			if exp-iat != Lifetime.Seconds() || time.Since(time.Unix(int64(iat), 0)) > time.Minute {
+				t.Errorf("iat = %v, exp = %v", iat, exp)
+			}
+			if jti, _ := claims["jti"].(string); len(jti) != 32 {
+				t.Errorf("jti = %q", claims["jti"])
+			}
+		})
+	}
+}
+
+func TestSignAudienceAndKeyID(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	token, err := Sign(key, "", Claims{Issuer: "iss", Audience: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, claims := verify(t, token, key.Public())
+	if _, ok := header["kid"]; ok {
+		t.Errorf("kid sent without a key id: %v", header)
+	}
+	if aud, _ := claims["aud"].([]any); len(aud) != 2 || aud[0] != "a" || aud[1] != "b" {
+		t.Errorf("aud = %v, want [a b]", claims["aud"])
+	}
+	if _, ok := claims["scope"]; ok {
+		t.Errorf("empty scope sent: %v", claims)
+	}
+
+	token, _ = Sign(key, "", Claims{Issuer: "iss"})
+	if _, claims := verify(t, token, key.Public()); claims["aud"] != nil {
+		t.Errorf("aud = %v without an audience", claims["aud"])
+	}
+}
+
+func TestLoadKeyRejectsUnsupportedKeys(t *testing.T) {
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	sec1, _ := x509.MarshalECPrivateKey(p521)
+
+	tests := []struct {
+		name      string
+		blockType string
+		der       []byte
+		want      string
+	}{
+		{"P-224", "PRIVATE KEY", pkcs8(t, p224), "unsupported elliptic curve"},
+		{"P-521 SEC1", "EC PRIVATE KEY", sec1, "unsupported elliptic curve"},
+		{"garbage PKCS8", "PRIVATE KEY", []byte("not a key"), "key.pem: "},
+		{"garbage PKCS1", "RSA PRIVATE KEY", []byte("not a key"), "key.pem: "},
+	}
+	for _, tt := range tests {
+		_, err := LoadKey(writePEM(t, tt.blockType, tt.der))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(path, []byte("no pem here"), 0o600)
+	if _, err := LoadKey(path); err == nil || !strings.Contains(err.Error(), "no PEM data") {
+		t.Errorf("non-PEM file: err = %v", err)
+	}
+}
+
+func TestLoadKeyFileServiceAccount(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(t, key)})
+	data, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"private_key":    string(keyPEM),
+		"private_key_id": "abc123",
+		"client_email":   "robot@project.iam.gserviceaccount.com",
+		"token_uri":      "https://oauth2.googleapis.com/token",
+	})
+	path := filepath.Join(t.TempDir(), "sa.json")
+	os.WriteFile(path, data, 0o600)
+
+	file, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.KeyID != "abc123" || file.Issuer != "robot@project.iam.gserviceaccount.com" || file.TokenURI != "https://oauth2.googleapis.com/token" {
+		t.Errorf("key file = %+v", file)
+	}
+	if _, ok := file.Signer.(*rsa.PrivateKey); !ok {
+		t.Errorf("signer = %T, want *rsa.PrivateKey", file.Signer)
+	}
+
+	pemPath := writePEM(t, "PRIVATE KEY", pkcs8(t, key))
+	file, err = LoadKeyFile(pemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.KeyID != "" || file.Issuer != "" || file.TokenURI != "" {
+		t.Errorf("PEM key file = %+v, want only a signer", file)
+	}
+}
@@ -0,0 +1,76 @@
+// Package logging configures the daemon's structured logger.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+const (
+	defaultMaxSizeMB  = 10
+	defaultMaxBackups = 3
+)
+
+// Setup installs the slog default logger described by cfg: level, text or JSON
+// format, and stderr or a rotating log file. Every record passes through a
+// RedactHandler. The standard log package is routed to the same handler. The
+// returned closer releases the log file, if any.
+func Setup(cfg config.Log) (io.Closer, error) {
+	level, err := parseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+	if cfg.File != "" {
+		maxSize := cfg.MaxSizeMB
+		if maxSize <= 0 {
+			maxSize = defaultMaxSizeMB
+		}
+		maxBackups := cfg.MaxBackups
+		if maxBackups <= 0 {
+			maxBackups = defaultMaxBackups
+		}
+		writer, err := newRotatingWriter(cfg.File, int64(maxSize)<<20, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = writer
+		closer = writer
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		closer.Close()
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(NewRedactHandler(handler)))
+	return closer, nil
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+}
@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are always replaced.
+var sensitiveKeys = map[string]bool{
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"client_secret": true,
+	"assertion":     true,
+	"password":      true,
+	"secret":        true,
+	"authorization": true,
+}
+
+// sensitiveParams are the form and query parameters, besides sensitiveKeys, whose
+// values are replaced in url.Values.
+var sensitiveParams = map[string]bool{
+	"code":             true,
+	"client_assertion": true,
+	"subject_token":    true,
+	"actor_token":      true,
+}
+
+// sensitiveHeaders are the header names, besides sensitiveKeys, whose values are
+// replaced in http.Header.
+var sensitiveHeaders = map[string]bool{
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+}
+
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Also matches Go field names such as AccessToken in values formatted with %+v.
+	{regexp.MustCompile(`(?i)\b(access_?token|refresh_?token|id_?token|client_?secret|client_?assertion|assertion|subject_?token|password)("?\s*[:=]\s*"?)([^"&\s,}]+)`), "${1}${2}" + redacted},
+	// After the parameters, so "TokenType:Bearer RefreshToken:..." keeps its
+	// field name for the pattern above.
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), "${1}" + redacted},
+	// The authorization code only as a query or form parameter or a JSON field, so
+	// "status code: 401" and "exit code=1" are kept.
+	{regexp.MustCompile(`((?:^|[?&])code=|"code"\s*:\s*")([^"&\s]+)`), "${1}" + redacted},
+}
+
+// RedactHandler removes token values from records before passing them on: attributes
+// with sensitive keys or oauth2.Token values are replaced, sensitive url.Values and
+// http.Header entries are masked, and bearer tokens or token parameters embedded in
+// messages, string values and other formatted values are masked.
+type RedactHandler struct {
+	inner slog.Handler
+}
+
+func NewRedactHandler(inner slog.Handler) *RedactHandler {
+	return &RedactHandler{inner: inner}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, record slog.Record) error {
+	clean := slog.NewRecord(record.Time, record.Level, Scrub(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		clean.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.inner.Handle(ctx, clean)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	cleaned := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		cleaned[i] = redactAttr(attr)
+	}
+	return &RedactHandler{inner: h.inner.WithAttrs(cleaned)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{inner: h.inner.WithGroup(name)}
+}
+
+// Scrub masks bearer tokens and token-bearing parameters in s.
+func Scrub(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Scrub(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		cleaned := make([]any, len(group))
+		for i, member := range group {
+			cleaned[i] = redactAttr(member)
+		}
+		return slog.Group(attr.Key, cleaned...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case *oauth2.Token, oauth2.Token:
+			return slog.String(attr.Key, redacted)
+		case error:
+			return slog.String(attr.Key, Scrub(v.Error()))
+		case url.Values:
+			return slog.Any(attr.Key, redactValues(v))
+		case http.Header:
+			return slog.Any(attr.Key, redactHeader(v))
+		default:
+			if scrubbed, ok := scrubFormatted(v); ok {
+				return slog.String(attr.Key, scrubbed)
+			}
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+func redactValues(values url.Values) url.Values {
+	clean := make(url.Values, len(values))
+	for name, list := range values {
+		lower := strings.ToLower(name)
+		clean[name] = redactList(list, sensitiveKeys[lower] || sensitiveParams[lower])
+	}
+	return clean
+}
+
+func redactHeader(header http.Header) http.Header {
+	clean := make(http.Header, len(header))
+	for name, list := range header {
+		lower := strings.ToLower(name)
+		clean[name] = redactList(list, sensitiveKeys[lower] || sensitiveHeaders[lower])
+	}
+	return clean
+}
+
+func redactList(list []string, sensitive bool) []string {
+	clean := make([]string, len(list))
+	for i, value := range list {
+		if sensitive {
+			clean[i] = redacted
+		} else {
+			clean[i] = Scrub(value)
+		}
+	}
+	return clean
+}
+
+// scrubFormatted formats v as the text handler (%+v) and the JSON handler would and
+// returns the scrubbed text when either form reveals a secret. Values that reveal
+// nothing keep their type, so handlers still render them natively.
+func scrubFormatted(v any) (string, bool) {
+	text := fmt.Sprintf("%+v", v)
+	if scrubbed := Scrub(text); scrubbed != text {
+		return scrubbed, true
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	if scrubbed := Scrub(string(encoded)); scrubbed != string(encoded) {
+		return scrubbed, true
+	}
+	return "", false
+}
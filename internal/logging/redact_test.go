@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRedactHandlerRemovesTokenValues(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewRedactHandler(slog.NewJSONHandler(&buf, nil)))
+
+	token := &oauth2.Token{AccessToken: "secret-access", RefreshToken: "secret-refresh"}
+	logger.With("refresh_token", "secret-refresh").Info(
+		"request sent with Authorization: Bearer secret-access",
+		"token", token.AccessToken,
+		"payload", token,
+		"error", errors.New(`oauth2: "refresh_token":"secret-refresh" rejected`),
+		"form", "grant_type=refresh_token&refresh_token=secret-refresh",
+		slog.Group("req", "access_token", "secret-access"),
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"secret-access", "secret-refresh"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("log output leaks %q: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "grant_type=refresh_token") {
+		t.Fatalf("non-secret values should be kept: %s", out)
+	}
+}
+
+func TestRedactHandlerMasksStructuredValues(t *testing.T) {
+	token := &oauth2.Token{AccessToken: "secret-access", TokenType: "Bearer", RefreshToken: "secret-refresh"}
+	type request struct {
+		Account string
+		Token   *oauth2.Token
+	}
+	type saved struct {
+		Account string
+		Token   oauth2.Token
+	}
+	attrs := []any{
+		"form", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"secret-refresh"}, "code": {"secret-code"}},
+		"query", url.Values{"state": {"account:work"}, "note": {"access_token=secret-access"}},
+		"header", http.Header{"Authorization": {"Bearer secret-access"}, "Cookie": {"session=secret-cookie"}, "Accept": {"application/json"}},
+		"request", request{Account: "work", Token: token},
+		"saved", saved{Account: "work", Token: *token},
+		"scopes", []string{"openid", "email"},
+	}
+
+	for name, handler := range map[string]func(*bytes.Buffer) slog.Handler{
+		"json": func(buf *bytes.Buffer) slog.Handler { return slog.NewJSONHandler(buf, nil) },
+		"text": func(buf *bytes.Buffer) slog.Handler { return slog.NewTextHandler(buf, nil) },
+	} {
+		var buf bytes.Buffer
+		slog.New(NewRedactHandler(handler(&buf))).Info("values", attrs...)
+		out := buf.String()
+		for _, secret := range []string{"secret-access", "secret-refresh", "secret-code", "secret-cookie"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s: log output leaks %q: %s", name, secret, out)
+			}
+		}
+		for _, kept := range []string{"account:work", "application/json", "refresh_token", "email"} {
+			if !strings.Contains(out, kept) {
+				t.Errorf("%s: log output lost %q: %s", name, kept, out)
+			}
+		}
+		if name == "json" && !strings.Contains(out, `"scopes":["openid","email"]`) {
+			t.Errorf("json: values without secrets should keep their type: %s", out)
+		}
+	}
+}
+
+func TestScrubAuthorizationCode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"GET /?code=abc123&state=account:work", "GET /?code=[REDACTED]&state=account:work"},
+		{"client_id=app&code=abc123&grant_type=authorization_code", "client_id=app&code=[REDACTED]&grant_type=authorization_code"},
+		{"code=abc123&grant_type=authorization_code", "code=[REDACTED]&grant_type=authorization_code"},
+		{`{"code":"abc123"}`, `{"code":"[REDACTED]"}`},
+		{"unexpected status code: 401", "unexpected status code: 401"},
+		{"hook exited with exit code=1", "hook exited with exit code=1"},
+		{"errorcode=7 statuscode=500", "errorcode=7 statuscode=500"},
+	}
+	for _, tt := range tests {
+		if got := Scrub(tt.in); got != tt.want {
+			t.Errorf("Scrub(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rotatingWriter appends to a log file and rotates it once it exceeds maxSize bytes,
+// keeping maxBackups old files named <path>.1 (newest) to <path>.<maxBackups>.
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newRotatingWriter(path string, maxSize int64, maxBackups int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var rotateErr error
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		// A failed rotation keeps appending to the current file and is retried
+		// with the next write.
+		rotateErr = w.rotate()
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil && rotateErr != nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate moves the current file to <path>.1 and opens a new one. The file is closed
+// for the rename, which Windows needs; when the rename fails the current file is
+// opened again for appending.
+func (w *rotatingWriter) rotate() error {
+	w.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxBackups))
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	renameErr := os.Rename(w.path, w.path+".1")
+	if os.IsNotExist(renameErr) {
+		renameErr = nil
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingWriterRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vygrant.log")
+	w, err := newRotatingWriter(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, want := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		if got, _ := os.ReadFile(file); string(got) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+}
+
+func TestRotatingWriterKeepsLoggingWhenRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vygrant.log")
+	w, err := newRotatingWriter(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A non-empty directory in place of the backup makes the rename fail.
+	blocker := filepath.Join(path+".1", "keep")
+	if err := os.MkdirAll(blocker, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("second\n")); err != nil {
+		t.Fatalf("write after a failed rotation: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "first\nsecond\n" {
+		t.Errorf("log = %q, want both lines appended", got)
+	}
+
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+	backup, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if string(backup) != "first\nsecond\n" || !strings.HasPrefix(string(current), "third") {
+		t.Errorf("after the retried rotation: backup %q, log %q", backup, current)
+	}
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestCounterEscapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("vygrant_test_total", "Test counter.", "account")
+	c.Inc(`we"ird\name` + "\nline")
+	c.Add(2.5, "plain")
+
+	want := `# HELP vygrant_test_total Test counter.
+# TYPE vygrant_test_total counter
+vygrant_test_total{account="plain"} 2.5
+vygrant_test_total{account="we\"ird\\name\nline"} 1
+`
+	if got := render(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGaugeSetAndDelete(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGaugeVec("vygrant_test_expiry", "Test gauge.", "account", "kind")
+	g.Set(10, "a", "access")
+	g.Set(-1, "b", "refresh")
+	g.Set(20, "a", "access")
+	g.Delete("b", "refresh")
+
+	want := `# HELP vygrant_test_expiry Test gauge.
+# TYPE vygrant_test_expiry gauge
+vygrant_test_expiry{account="a",kind="access"} 20
+`
+	if got := render(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramBucketsSumAndCount(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("vygrant_test_seconds", "Test histogram.", []float64{0.1, 1, 5}, "command")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3, 60} {
+		h.Observe(v, "get-token")
+	}
+	h.Observe(0.2, "status")
+
+	want := `# HELP vygrant_test_seconds Test histogram.
+# TYPE vygrant_test_seconds histogram
+vygrant_test_seconds_bucket{command="get-token",le="0.1"} 2
+vygrant_test_seconds_bucket{command="get-token",le="1"} 3
+vygrant_test_seconds_bucket{command="get-token",le="5"} 4
+vygrant_test_seconds_bucket{command="get-token",le="+Inf"} 5
+vygrant_test_seconds_sum{command="get-token"} 63.65
+vygrant_test_seconds_count{command="get-token"} 5
+vygrant_test_seconds_bucket{command="status",le="0.1"} 0
+vygrant_test_seconds_bucket{command="status",le="1"} 1
+vygrant_test_seconds_bucket{command="status",le="5"} 1
+vygrant_test_seconds_bucket{command="status",le="+Inf"} 1
+vygrant_test_seconds_sum{command="status"} 0.2
+vygrant_test_seconds_count{command="status"} 1
+`
+	if got := render(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("vygrant_test_check_seconds", "Unlabelled.", []float64{1})
+	h.Observe(2)
+
+	want := `# HELP vygrant_test_check_seconds Unlabelled.
+# TYPE vygrant_test_check_seconds histogram
+vygrant_test_check_seconds_bucket{le="1"} 0
+vygrant_test_check_seconds_bucket{le="+Inf"} 1
+vygrant_test_check_seconds_sum 2
+vygrant_test_check_seconds_count 1
+`
+	if got := render(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandlerContentType(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("vygrant_test_total", "Test counter.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "vygrant_test_total 1\n") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with a missing label value did not panic")
+		}
+	}()
+	NewRegistry().NewCounterVec("vygrant_test_total", "Test counter.", "account").Inc()
+}
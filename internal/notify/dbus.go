@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/vybraan/vygrant/internal/browser"
+)
+
+const (
+	notificationsName      = "org.freedesktop.Notifications"
+	notificationsPath      = "/org/freedesktop/Notifications"
+	notificationsInterface = "org.freedesktop.Notifications"
+
+	reauthAction = "reauth"
+)
+
+// DBus sends freedesktop notifications over the session bus. Notifications with an
+// ActionURL get a "Re-authenticate" button that opens the URL with the Browser
+// command (see browser.Open).
+type DBus struct {
+	Browser string
+
+	conn *dbus.Conn
+
+	mu      sync.Mutex
+	actions map[uint32]string
+}
+
+// NewDBus connects to the session bus and listens for notification actions, which
+// open their URL with browserCommand.
+func NewDBus(browserCommand string) (*DBus, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchInterface(notificationsInterface),
+		dbus.WithMatchMember("ActionInvoked"),
+	); err != nil {
+		return nil, err
+	}
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchInterface(notificationsInterface),
+		dbus.WithMatchMember("NotificationClosed"),
+	); err != nil {
+		return nil, err
+	}
+	d := &DBus{Browser: browserCommand, conn: conn, actions: map[uint32]string{}}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	go d.handleSignals(signals)
+	return d, nil
+}
+
+func (d *DBus) Notify(n Notification) error {
+	var actions []string
+	if n.ActionURL != "" {
+		actions = []string{reauthAction, "Re-authenticate"}
+	}
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(urgency(n.Severity)),
+	}
+	var id uint32
+	err := d.conn.Object(notificationsName, notificationsPath).Call(
+		notificationsInterface+".Notify", 0,
+		"vygrant", uint32(0), "dialog-password", n.Title, n.Message, actions, hints, int32(-1),
+	).Store(&id)
+	if err != nil {
+		return err
+	}
+	if n.ActionURL != "" {
+		d.mu.Lock()
+		d.actions[id] = n.ActionURL
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+func (d *DBus) handleSignals(signals <-chan *dbus.Signal) {
+	for signal := range signals {
+		if len(signal.Body) == 0 {
+			continue
+		}
+		id, ok := signal.Body[0].(uint32)
+		if !ok {
+			continue
+		}
+		d.mu.Lock()
+		url, known := d.actions[id]
+		delete(d.actions, id)
+		d.mu.Unlock()
+		if !known || signal.Name != notificationsInterface+".ActionInvoked" || len(signal.Body) < 2 {
+			continue
+		}
+		if action, _ := signal.Body[1].(string); action != reauthAction {
+			continue
+		}
+		if err := browser.Open(d.Browser, url); err != nil {
+			slog.Warn("could not open browser", "url", url, "error", err)
+		}
+	}
+}
+
+// urgency maps a severity to the freedesktop urgency hint: low, normal or critical.
+func urgency(s Severity) byte {
+	switch s {
+	case Error:
+		return 2
+	case Warning:
+		return 1
+	default:
+		return 0
+	}
+}
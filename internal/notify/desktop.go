@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Desktop shows notifications with the platform's command line tools: notify-send,
+// osascript or PowerShell. It has no actions.
+type Desktop struct{}
+
+func (Desktop) Notify(n Notification) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.Command("notify-send", n.Title, n.Message)
+	case "darwin":
+		script := fmt.Sprintf(`display notification %q with title %q`, n.Message, n.Title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		ps := fmt.Sprintf(`[reflection.assembly]::LoadWithPartialName('System.Windows.Forms');`+
+			`[System.Windows.Forms.MessageBox]::Show('%s','%s')`, n.Message, n.Title)
+		cmd = exec.Command("powershell", "-Command", ps)
+	}
+
+	if cmd == nil {
+		return nil
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+const (
+	defaultRateLimit   = time.Minute
+	defaultDedupWindow = 6 * time.Hour
+)
+
+// Filter drops notifications below the minimum severity, notifications sent again
+// for the same event and account within the rate limit, and repeats of an identical
+// message within the dedup window. Forget clears the history of an account once it
+// recovered, so its next failure is reported again.
+type Filter struct {
+	next        Notifier
+	minSeverity Severity
+	severities  map[string]Severity
+	disabled    map[string]bool
+	rateLimit   time.Duration
+	dedupWindow time.Duration
+	now         func() time.Time
+
+	mu   sync.Mutex
+	last map[historyKey]time.Time
+	seen map[historyKey]time.Time
+}
+
+type historyKey struct {
+	event, account, text string
+}
+
+// NewFilter wraps next with the filtering settings of cfg.
+func NewFilter(next Notifier, cfg config.Notify) (*Filter, error) {
+	minSeverity, err := ParseSeverity(cfg.MinSeverity)
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{
+		next:        next,
+		minSeverity: minSeverity,
+		severities:  map[string]Severity{},
+		disabled:    map[string]bool{},
+		rateLimit:   cfg.RateLimit,
+		dedupWindow: cfg.DedupWindow,
+		now:         time.Now,
+		last:        map[historyKey]time.Time{},
+		seen:        map[historyKey]time.Time{},
+	}
+	if f.rateLimit == 0 {
+		f.rateLimit = defaultRateLimit
+	}
+	if f.dedupWindow == 0 {
+		f.dedupWindow = defaultDedupWindow
+	}
+	for event, value := range cfg.Severity {
+		if value == "off" {
+			f.disabled[event] = true
+			continue
+		}
+		severity, err := ParseSeverity(value)
+		if err != nil {
+			return nil, fmt.Errorf("notify severity for %s: %w", event, err)
+		}
+		f.severities[event] = severity
+	}
+	return f, nil
+}
+
+func (f *Filter) Notify(n Notification) error {
+	if !f.allow(&n) {
+		return nil
+	}
+	return f.next.Notify(n)
+}
+
+func (f *Filter) allow(n *Notification) bool {
+	if f.disabled[n.Event] {
+		return false
+	}
+	if severity, ok := f.severities[n.Event]; ok {
+		n.Severity = severity
+	}
+	if n.Severity < f.minSeverity {
+		return false
+	}
+
+	now := f.now()
+	rateKey := historyKey{event: n.Event, account: n.Account}
+	dedupKey := historyKey{event: n.Event, account: n.Account, text: n.Title + "\n" + n.Message}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prune(now)
+	if last, ok := f.last[rateKey]; ok && now.Sub(last) < f.rateLimit {
+		return false
+	}
+	if seen, ok := f.seen[dedupKey]; ok && now.Sub(seen) < f.dedupWindow {
+		return false
+	}
+	f.last[rateKey] = now
+	f.seen[dedupKey] = now
+	return true
+}
+
+// prune drops history that no longer holds back a notification, so messages whose
+// text varies do not pile up for the lifetime of the daemon.
+func (f *Filter) prune(now time.Time) {
+	for key, last := range f.last {
+		if now.Sub(last) >= f.rateLimit {
+			delete(f.last, key)
+		}
+	}
+	for key, seen := range f.seen {
+		if now.Sub(seen) >= f.dedupWindow {
+			delete(f.seen, key)
+		}
+	}
+}
+
+// Forget clears the rate limit and dedup history of account.
+func (f *Filter) Forget(account string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key := range f.last {
+		if key.account == account {
+			delete(f.last, key)
+		}
+	}
+	for key := range f.seen {
+		if key.account == account {
+			delete(f.seen, key)
+		}
+	}
+}
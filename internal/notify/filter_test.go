@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+// recorder collects the notifications that pass the filter.
+type recorder struct {
+	got []Notification
+}
+
+func (r *recorder) Notify(n Notification) error {
+	r.got = append(r.got, n)
+	return nil
+}
+
+// newTestFilter returns a filter over a recorder whose clock is advanced by the
+// returned function.
+func newTestFilter(t *testing.T, cfg config.Notify) (*Filter, *recorder, func(time.Duration)) {
+	t.Helper()
+	rec := &recorder{}
+	f, err := NewFilter(rec, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	return f, rec, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestFilterSeverity(t *testing.T) {
+	f, rec, _ := newTestFilter(t, config.Notify{
+		MinSeverity: "warning",
+		Severity:    map[string]string{"refresh_ok": "off", "auth_required": "error", "refresh_failed": "info"},
+	})
+	tests := []struct {
+		event    string
+		severity Severity
+		want     bool
+		wantSev  Severity
+	}{
+		{"refresh_ok", Error, false, 0},
+		{"refresh_rejected", Error, true, Error},
+		{"refresh_rotated", Warning, true, Warning},
+		{"refresh_failed", Warning, false, 0},
+		{"auth_required", Info, true, Error},
+		{"other", Info, false, 0},
+	}
+	for _, tt := range tests {
+		rec.got = nil
+		f.Notify(Notification{Event: tt.event, Account: "work", Severity: tt.severity})
+		if sent := len(rec.got) == 1; sent != tt.want {
+			t.Errorf("%s: sent = %v, want %v", tt.event, sent, tt.want)
+			continue
+		}
+		if tt.want && rec.got[0].Severity != tt.wantSev {
+			t.Errorf("%s: severity = %v, want %v", tt.event, rec.got[0].Severity, tt.wantSev)
+		}
+	}
+}
+
+func TestFilterRateLimitAndDedup(t *testing.T) {
+	f, rec, advance := newTestFilter(t, config.Notify{RateLimit: time.Minute, DedupWindow: time.Hour})
+	send := func(event, account, message string) {
+		f.Notify(Notification{Event: event, Account: account, Severity: Warning, Title: "t", Message: message})
+	}
+
+	send("refresh_failed", "work", "first")
+	send("refresh_failed", "work", "second")  // rate limited
+	send("refresh_failed", "home", "first")   // other account
+	send("refresh_rejected", "work", "first") // other event
+	advance(2 * time.Minute)
+	send("refresh_failed", "work", "first")  // duplicate within the dedup window
+	send("refresh_failed", "work", "second") // new text after the rate limit
+	advance(2 * time.Hour)
+	send("refresh_failed", "work", "first") // dedup window passed
+
+	want := []string{"work first", "home first", "work first", "work second", "work first"}
+	if len(rec.got) != len(want) {
+		t.Fatalf("sent %d notifications, want %d: %+v", len(rec.got), len(want), rec.got)
+	}
+	for i, n := range rec.got {
+		if got := n.Account + " " + n.Message; got != want[i] {
+			t.Errorf("notification %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestFilterPrunesExpiredHistory(t *testing.T) {
+	f, rec, advance := newTestFilter(t, config.Notify{RateLimit: time.Minute, DedupWindow: time.Hour})
+	for i := range 5 {
+		f.Notify(Notification{Event: "refresh_failed", Account: "work", Severity: Warning, Message: fmt.Sprint("error ", i)})
+		advance(2 * time.Minute)
+	}
+	if len(rec.got) != 5 {
+		t.Fatalf("sent %d notifications, want 5", len(rec.got))
+	}
+	if len(f.last) != 1 || len(f.seen) != 5 {
+		t.Errorf("history after the rate limit: %d rate entries and %d dedup entries, want 1 and 5", len(f.last), len(f.seen))
+	}
+
+	advance(time.Hour)
+	f.Notify(Notification{Event: "refresh_failed", Account: "home", Severity: Warning, Message: "error"})
+	if len(f.last) != 1 || len(f.seen) != 1 {
+		t.Errorf("history after the dedup window: %d rate entries and %d dedup entries, want only the new one", len(f.last), len(f.seen))
+	}
+}
+
+func TestFilterForget(t *testing.T) {
+	f, rec, _ := newTestFilter(t, config.Notify{})
+	n := Notification{Event: "refresh_failed", Account: "work", Severity: Warning, Message: "down"}
+	other := Notification{Event: "refresh_failed", Account: "home", Severity: Warning, Message: "down"}
+	f.Notify(n)
+	f.Notify(other)
+	f.Forget("work")
+	f.Notify(n)
+	f.Notify(other)
+	if len(rec.got) != 3 || rec.got[2].Account != "work" {
+		t.Errorf("sent %+v, want the forgotten account's notification repeated", rec.got)
+	}
+}
+
+func TestNewFilterDefaultsAndErrors(t *testing.T) {
+	f, err := NewFilter(None{}, config.Notify{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.rateLimit != defaultRateLimit || f.dedupWindow != defaultDedupWindow || f.minSeverity != Info {
+		t.Errorf("defaults = %v, %v, %v", f.rateLimit, f.dedupWindow, f.minSeverity)
+	}
+	if _, err := NewFilter(None{}, config.Notify{MinSeverity: "loud"}); err == nil {
+		t.Error("unknown min_severity accepted")
+	}
+	if _, err := NewFilter(None{}, config.Notify{Severity: map[string]string{"refresh_ok": "loud"}}); err == nil {
+		t.Error("unknown event severity accepted")
+	}
+}
@@ -0,0 +1,140 @@
+// Package notify delivers user notifications through the backend selected in the
+// [notify] config section.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+// Severity orders notifications for filtering.
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseSeverity parses info, warning or error.
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return Info, nil
+	case "warning", "warn":
+		return Warning, nil
+	case "error":
+		return Error, nil
+	default:
+		return Info, fmt.Errorf("unknown severity %q (want info, warning or error)", s)
+	}
+}
+
+// Notification is one message to the user. Event names what happened, for example
+// refresh_failed; ActionURL, when set, is offered as a "Re-authenticate" action.
+type Notification struct {
+	Event     string
+	Account   string
+	Severity  Severity
+	Title     string
+	Message   string
+	ActionURL string
+}
+
+// Notifier delivers notifications.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// None discards every notification.
+type None struct{}
+
+func (None) Notify(Notification) error { return nil }
+
+const commandTimeout = 10 * time.Second
+
+// Command runs a shell command for each notification with the details in
+// VYGRANT_NOTIFY_* environment variables.
+type Command struct {
+	Command string
+}
+
+func (c Command) Notify(n Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Env = append(os.Environ(),
+		"VYGRANT_NOTIFY_EVENT="+n.Event,
+		"VYGRANT_NOTIFY_ACCOUNT="+n.Account,
+		"VYGRANT_NOTIFY_SEVERITY="+n.Severity.String(),
+		"VYGRANT_NOTIFY_TITLE="+n.Title,
+		"VYGRANT_NOTIFY_MESSAGE="+n.Message,
+		"VYGRANT_NOTIFY_URL="+n.ActionURL,
+	)
+	return cmd.Run()
+}
+
+// Validate checks the [notify] section without connecting to anything.
+func Validate(cfg config.Notify) error {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", "desktop", "dbus", "none":
+	case "webhook":
+		if cfg.WebhookURL == "" {
+			return fmt.Errorf("notify backend webhook needs webhook_url")
+		}
+	case "command":
+		if cfg.Command == "" {
+			return fmt.Errorf("notify backend command needs command")
+		}
+	default:
+		return fmt.Errorf("unknown notify backend %q (want desktop, dbus, webhook, command or none)", cfg.Backend)
+	}
+	if cfg.RateLimit < 0 || cfg.DedupWindow < 0 {
+		return fmt.Errorf("notify rate_limit and dedup_window must not be negative")
+	}
+	_, err := NewFilter(None{}, cfg)
+	return err
+}
+
+// New builds the notifier described by cfg, wrapped in a Filter. browserCommand opens
+// the URLs of notification actions.
+func New(cfg config.Notify, browserCommand string) (Notifier, error) {
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	var backend Notifier
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "dbus":
+		dbus, err := NewDBus(browserCommand)
+		if err != nil {
+			return nil, err
+		}
+		backend = dbus
+	case "webhook":
+		backend = &Webhook{URL: cfg.WebhookURL, Topic: cfg.WebhookTopic, Headers: cfg.WebhookHeaders}
+	case "command":
+		backend = Command{Command: cfg.Command}
+	case "none":
+		return None{}, nil
+	default:
+		backend = Desktop{}
+	}
+	return NewFilter(backend, cfg)
+}
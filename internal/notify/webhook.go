@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook POSTs each notification as JSON. The body carries the fields read by
+// ntfy (topic, title, message, priority, actions), Gotify (title, message, priority)
+// and Slack incoming webhooks (text), so one URL of any of them works.
+type Webhook struct {
+	URL     string
+	Topic   string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+type webhookAction struct {
+	Action string `json:"action"`
+	Label  string `json:"label"`
+	URL    string `json:"url"`
+}
+
+type webhookBody struct {
+	Topic    string          `json:"topic,omitempty"`
+	Title    string          `json:"title"`
+	Message  string          `json:"message"`
+	Text     string          `json:"text"`
+	Priority int             `json:"priority"`
+	Actions  []webhookAction `json:"actions,omitempty"`
+	Event    string          `json:"event"`
+	Account  string          `json:"account,omitempty"`
+	Severity string          `json:"severity"`
+	URL      string          `json:"url,omitempty"`
+}
+
+func (w *Webhook) Notify(n Notification) error {
+	body := webhookBody{
+		Topic:    w.Topic,
+		Title:    n.Title,
+		Message:  n.Message,
+		Text:     n.Title + ": " + n.Message,
+		Priority: priority(n.Severity),
+		Event:    n.Event,
+		Account:  n.Account,
+		Severity: n.Severity.String(),
+		URL:      n.ActionURL,
+	}
+	if n.ActionURL != "" {
+		body.Actions = []webhookAction{{Action: "view", Label: "Re-authenticate", URL: n.ActionURL}}
+		if !strings.Contains(body.Text, n.ActionURL) {
+			body.Text += " " + n.ActionURL
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// priority maps a severity to a priority that ntfy (1-5) and Gotify (0-10) both
+// accept.
+func priority(s Severity) int {
+	switch s {
+	case Error:
+		return 5
+	case Warning:
+		return 4
+	default:
+		return 2
+	}
+}
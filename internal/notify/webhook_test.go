@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookBody(t *testing.T) {
+	var body map[string]any
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid body %q: %v", data, err)
+		}
+	}))
+	defer server.Close()
+
+	w := &Webhook{URL: server.URL, Topic: "vygrant", Headers: map[string]string{"X-Gotify-Key": "k"}}
+	err := w.Notify(Notification{
+		Event: "refresh_rejected", Account: "work", Severity: Error,
+		Title: "vygrant - auto refresh failed", Message: "Please re-authenticate.",
+		ActionURL: "http://localhost:8080/auth/work",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Content-Type") != "application/json" || header.Get("X-Gotify-Key") != "k" {
+		t.Errorf("headers = %v", header)
+	}
+
+	// ntfy reads topic, title, message, priority (1-5) and actions.
+	want := map[string]any{
+		"topic":    "vygrant",
+		"title":    "vygrant - auto refresh failed",
+		"message":  "Please re-authenticate.",
+		"priority": float64(5),
+		"event":    "refresh_rejected",
+		"account":  "work",
+		"severity": "error",
+		"url":      "http://localhost:8080/auth/work",
+		// Slack incoming webhooks only read text.
+		"text": "vygrant - auto refresh failed: Please re-authenticate. http://localhost:8080/auth/work",
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %v", key, body[key], value)
+		}
+	}
+	actions, _ := body["actions"].([]any)
+	if len(actions) != 1 {
+		t.Fatalf("actions = %v, want one view action", body["actions"])
+	}
+	action := actions[0].(map[string]any)
+	if action["action"] != "view" || action["label"] != "Re-authenticate" || action["url"] != "http://localhost:8080/auth/work" {
+		t.Errorf("action = %v", action)
+	}
+}
+
+func TestWebhookPriority(t *testing.T) {
+	// Gotify accepts 0-10 and ntfy 1-5; both order them the same way.
+	for severity, want := range map[Severity]int{Info: 2, Warning: 4, Error: 5} {
+		if got := priority(severity); got != want {
+			t.Errorf("priority(%v) = %d, want %d", severity, got, want)
+		}
+	}
+}
+
+func TestWebhookReportsHTTPErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	w := &Webhook{URL: server.URL}
+	if err := w.Notify(Notification{Event: "refresh_ok", Title: "t", Message: "m"}); err == nil {
+		t.Error("webhook error status not reported")
+	}
+}
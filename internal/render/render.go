@@ -0,0 +1,185 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+const (
+	defaultMode    = 0o600
+	commandTimeout = 30 * time.Second
+)
+
+// TokenFunc returns the current access token for account.
+type TokenFunc func(account string) (string, error)
+
+type entry struct {
+	cfg  config.Template
+	tmpl *template.Template
+	mode os.FileMode
+
+	// refs holds the accounts the template asked for during its last render.
+	refs map[string]struct{}
+}
+
+// Renderer renders [[template]] blocks to their destinations, re-rendering a file
+// whenever one of the accounts it references gets a new token.
+type Renderer struct {
+	token   TokenFunc
+	mu      sync.Mutex
+	entries []*entry
+}
+
+// New parses every configured template. The templates can call {{ token "account" }}
+// to insert that account's access token, which is fetched through token.
+func New(templates []config.Template, token TokenFunc) (*Renderer, error) {
+	r := &Renderer{token: token}
+	for _, cfg := range templates {
+		mode, err := parseMode(cfg.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", cfg.Source, err)
+		}
+		e := &entry{cfg: cfg, mode: mode, refs: map[string]struct{}{}}
+		tmpl, err := template.New(filepath.Base(cfg.Source)).
+			Funcs(template.FuncMap{"token": e.tokenFunc(r)}).
+			ParseFiles(cfg.Source)
+		if err != nil {
+			return nil, err
+		}
+		e.tmpl = tmpl
+		r.entries = append(r.entries, e)
+	}
+	return r, nil
+}
+
+func (e *entry) tokenFunc(r *Renderer) func(string) (string, error) {
+	return func(account string) (string, error) {
+		e.refs[account] = struct{}{}
+		return r.token(account)
+	}
+}
+
+// RenderAll renders every template, logging failures.
+func (r *Renderer) RenderAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.entries {
+		r.render(e, false)
+	}
+}
+
+// Changed is a storage.ChangeFunc. It re-renders, in the background, the templates
+// that referenced account; a restore re-renders all of them. When account's token
+// was deleted and a template can no longer be rendered, its destination is removed
+// so the file does not keep the deleted token.
+func (r *Renderer) Changed(account, event string) {
+	if event != "set" && event != "delete" && event != "restore" {
+		return
+	}
+	go func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, e := range r.entries {
+			if _, ok := e.refs[account]; ok || account == "*" {
+				r.render(e, event == "delete")
+			}
+		}
+	}()
+}
+
+// render executes e and atomically replaces its destination when the output changed,
+// then runs the configured command. Failures leave the previous file in place unless
+// removeOnError is set.
+func (r *Renderer) render(e *entry, removeOnError bool) {
+	// A failed render stops at the first missing token, so the accounts referenced
+	// before are kept to re-render once that token is back.
+	previous := maps.Clone(e.refs)
+	clear(e.refs)
+	var buf bytes.Buffer
+	if err := e.tmpl.Execute(&buf, nil); err != nil {
+		maps.Copy(e.refs, previous)
+		if !removeOnError {
+			slog.Error("template render failed", "template", e.cfg.Source, "error", err)
+			return
+		}
+		if err := os.Remove(e.cfg.Destination); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("template remove failed", "template", e.cfg.Source, "destination", e.cfg.Destination, "error", err)
+			return
+		}
+		slog.Warn("template removed after token deletion", "template", e.cfg.Source, "destination", e.cfg.Destination, "error", err)
+		return
+	}
+
+	if current, err := os.ReadFile(e.cfg.Destination); err == nil && bytes.Equal(current, buf.Bytes()) {
+		return
+	}
+	if err := writeAtomic(e.cfg.Destination, buf.Bytes(), e.mode); err != nil {
+		slog.Error("template write failed", "template", e.cfg.Source, "destination", e.cfg.Destination, "error", err)
+		return
+	}
+	slog.Info("template rendered", "template", e.cfg.Source, "destination", e.cfg.Destination)
+
+	if e.cfg.Command != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", e.cfg.Command)
+		cmd.Env = append(os.Environ(), "VYGRANT_TEMPLATE="+e.cfg.Destination)
+		if err := cmd.Run(); err != nil {
+			slog.Warn("template command failed", "template", e.cfg.Source, "error", err)
+		}
+	}
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it over path,
+// so readers never observe a partially written file.
+func writeAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func parseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return defaultMode, nil
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+	return os.FileMode(parsed), nil
+}
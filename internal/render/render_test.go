@@ -0,0 +1,189 @@
+package render
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vybraan/vygrant/internal/config"
+)
+
+// tokens is a TokenFunc backed by a map; missing accounts fail like a deleted token.
+type tokens struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (t *tokens) get(account string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	value, ok := t.values[account]
+	if !ok {
+		return "", errors.New("no token")
+	}
+	return value, nil
+}
+
+func (t *tokens) set(account, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if value == "" {
+		delete(t.values, account)
+		return
+	}
+	t.values[account] = value
+}
+
+func newTestRenderer(t *testing.T, source, mode string, tok *tokens) (*Renderer, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "netrc.tmpl")
+	if err := os.WriteFile(src, []byte(source), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "out", "netrc")
+	marker := filepath.Join(dir, "runs")
+	r, err := New([]config.Template{{
+		Source:      src,
+		Destination: dest,
+		Mode:        mode,
+		Command:     "echo run >> " + marker,
+	}}, tok.get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, dest, marker
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// waitFor polls cond, as Changed renders in the background.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRenderAllWritesDestinationWithMode(t *testing.T) {
+	tok := &tokens{values: map[string]string{"mail": "tok-1"}}
+	r, dest, marker := newTestRenderer(t, `password {{ token "mail" }}`, "0640", tok)
+
+	r.RenderAll()
+
+	if got := readFile(t, dest); got != "password tok-1" {
+		t.Errorf("destination = %q", got)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if got := readFile(t, marker); got != "run\n" {
+		t.Errorf("command runs = %q, want one", got)
+	}
+
+	// Unchanged output is neither rewritten nor followed by the command.
+	r.RenderAll()
+	if got := readFile(t, marker); got != "run\n" {
+		t.Errorf("command ran again for unchanged output: %q", got)
+	}
+}
+
+func TestChangedRerendersReferencingTemplates(t *testing.T) {
+	tok := &tokens{values: map[string]string{"mail": "tok-1", "other": "x"}}
+	r, dest, _ := newTestRenderer(t, `password {{ token "mail" }}`, "", tok)
+	r.RenderAll()
+
+	tok.set("other", "y")
+	r.Changed("other", "set")
+	tok.set("mail", "tok-2")
+	r.Changed("mail", "set")
+	waitFor(t, "re-render", func() bool { return readFile(t, dest) == "password tok-2" })
+
+	tok.set("mail", "tok-3")
+	r.Changed("mail", "refresh_failed")
+	time.Sleep(50 * time.Millisecond)
+	if got := readFile(t, dest); got != "password tok-2" {
+		t.Errorf("destination = %q after an unrelated event", got)
+	}
+
+	r.Changed("*", "restore")
+	waitFor(t, "restore re-render", func() bool { return readFile(t, dest) == "password tok-3" })
+}
+
+func TestChangedRemovesDestinationWhenTokenDeleted(t *testing.T) {
+	tok := &tokens{values: map[string]string{"mail": "tok-1", "other": "x"}}
+	r, dest, _ := newTestRenderer(t, `{{ token "mail" }} {{ token "other" }}`, "", tok)
+	r.RenderAll()
+
+	// A failed set leaves the previous file in place.
+	tok.set("other", "")
+	r.Changed("other", "set")
+	time.Sleep(50 * time.Millisecond)
+	if got := readFile(t, dest); got != "tok-1 x" {
+		t.Fatalf("destination = %q after a failed render", got)
+	}
+
+	r.Changed("other", "delete")
+	waitFor(t, "removal", func() bool {
+		_, err := os.Stat(dest)
+		return errors.Is(err, os.ErrNotExist)
+	})
+
+	// The template still follows both accounts once the token is back.
+	tok.set("other", "y")
+	r.Changed("other", "set")
+	waitFor(t, "re-render", func() bool {
+		data, err := os.ReadFile(dest)
+		return err == nil && string(data) == "tok-1 y"
+	})
+}
+
+func TestDeleteKeepsRenderableTemplate(t *testing.T) {
+	tok := &tokens{values: map[string]string{"mail": "tok-1"}}
+	r, dest, _ := newTestRenderer(t, `{{ token "mail" }}`, "", tok)
+	r.RenderAll()
+
+	// An exchanged token is deleted and obtained again on the next request.
+	tok.set("mail", "tok-2")
+	r.Changed("mail", "delete")
+	waitFor(t, "re-render", func() bool { return readFile(t, dest) == "tok-2" })
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		mode string
+		want os.FileMode
+		ok   bool
+	}{
+		{"", 0o600, true},
+		{"0644", 0o644, true},
+		{"755", 0o755, true},
+		{"0800", 0, false},
+		{"01777", 0, false},
+		{"rw", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMode(tt.mode)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseMode(%q) = %v, %v; want %v, ok %v", tt.mode, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrorFunc receives storage failures; operation is "get", "set", "delete", "dump"
+// or "restore". Missing tokens are not reported.
+type ErrorFunc func(backend, operation string, err error)
+
+// InstrumentedStore wraps a TokenStore and reports its errors to an ErrorFunc.
+type InstrumentedStore struct {
+	inner   TokenStore
+	backend string
+	onError ErrorFunc
+}
+
+func NewInstrumentedStore(inner TokenStore, backend string, onError ErrorFunc) *InstrumentedStore {
+	return &InstrumentedStore{inner: inner, backend: backend, onError: onError}
+}
+
+func (i *InstrumentedStore) Inner() TokenStore {
+	return i.inner
+}
+
+func (i *InstrumentedStore) Set(account string, token *oauth2.Token) error {
+	return i.report("set", i.inner.Set(account, token))
+}
+
+func (i *InstrumentedStore) Get(account string) (*oauth2.Token, error) {
+	token, err := i.inner.Get(account)
+	return token, i.report("get", err)
+}
+
+func (i *InstrumentedStore) Delete(account string) error {
+	return i.report("delete", i.inner.Delete(account))
+}
+
+func (i *InstrumentedStore) ListAccounts() []string {
+	return i.inner.ListAccounts()
+}
+
+func (i *InstrumentedStore) Dump() ([]byte, error) {
+	if dumper, ok := i.inner.(TokenDumper); ok {
+		data, err := dumper.Dump()
+		return data, i.report("dump", err)
+	}
+	return nil, os.ErrInvalid
+}
+
+func (i *InstrumentedStore) Restore(data []byte) error {
+	if dumper, ok := i.inner.(TokenDumper); ok {
+		return i.report("restore", dumper.Restore(data))
+	}
+	return os.ErrInvalid
+}
+
+func (i *InstrumentedStore) report(operation string, err error) error {
+	if err != nil && !isNotExist(err) && i.onError != nil {
+		i.onError(i.backend, operation, err)
+	}
+	return err
+}
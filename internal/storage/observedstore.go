@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"os"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ChangeFunc is called after a token change has been written to the store. event is
+// "set", "delete" or "restore"; a restore reports account "*".
+type ChangeFunc func(account, event string)
+
+// ObservedStore wraps a TokenStore and calls the registered ChangeFuncs after every
+// successful Set, Delete and Restore.
+type ObservedStore struct {
+	inner TokenStore
+
+	mu        sync.RWMutex
+	observers []ChangeFunc
+}
+
+func NewObservedStore(inner TokenStore) *ObservedStore {
+	return &ObservedStore{inner: inner}
+}
+
+func (o *ObservedStore) Inner() TokenStore {
+	return o.inner
+}
+
+// Observe registers fn to be called on every token change.
+func (o *ObservedStore) Observe(fn ChangeFunc) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.observers = append(o.observers, fn)
+}
+
+func (o *ObservedStore) Set(account string, token *oauth2.Token) error {
+	if err := o.inner.Set(account, token); err != nil {
+		return err
+	}
+	o.notify(account, "set")
+	return nil
+}
+
+func (o *ObservedStore) Get(account string) (*oauth2.Token, error) {
+	return o.inner.Get(account)
+}
+
+func (o *ObservedStore) Delete(account string) error {
+	if err := o.inner.Delete(account); err != nil {
+		return err
+	}
+	o.notify(account, "delete")
+	return nil
+}
+
+func (o *ObservedStore) ListAccounts() []string {
+	return o.inner.ListAccounts()
+}
+
+func (o *ObservedStore) Dump() ([]byte, error) {
+	if dumper, ok := o.inner.(TokenDumper); ok {
+		return dumper.Dump()
+	}
+	return nil, os.ErrInvalid
+}
+
+func (o *ObservedStore) Restore(data []byte) error {
+	if dumper, ok := o.inner.(TokenDumper); ok {
+		if err := dumper.Restore(data); err != nil {
+			return err
+		}
+		o.notify("*", "restore")
+		return nil
+	}
+	return os.ErrInvalid
+}
+
+func (o *ObservedStore) notify(account, event string) {
+	o.mu.RLock()
+	observers := append([]ChangeFunc(nil), o.observers...)
+	o.mu.RUnlock()
+	for _, fn := range observers {
+		fn(account, event)
+	}
+}
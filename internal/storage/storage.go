@@ -13,3 +13,27 @@ type TokenDumper interface {
 	Dump() ([]byte, error)
 	Restore(data []byte) error
 }
+
+// wrapper is implemented by the stores that decorate another store. They always
+// have Dump and Restore methods, which fail unless the wrapped store supports them.
+type wrapper interface {
+	Inner() TokenStore
+}
+
+// Dumper returns store as a TokenDumper if the store it decorates supports dump and
+// restore. Going through store keeps the decorations, such as change observers.
+func Dumper(store TokenStore) (TokenDumper, bool) {
+	inner := store
+	for {
+		w, ok := inner.(wrapper)
+		if !ok {
+			break
+		}
+		inner = w.Inner()
+	}
+	if _, ok := inner.(TokenDumper); !ok {
+		return nil, false
+	}
+	dumper, ok := store.(TokenDumper)
+	return dumper, ok
+}
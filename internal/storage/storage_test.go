@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// plainStore is a TokenStore without dump support.
+type plainStore struct{}
+
+func (plainStore) Set(string, *oauth2.Token) error   { return nil }
+func (plainStore) Get(string) (*oauth2.Token, error) { return nil, nil }
+func (plainStore) Delete(string) error               { return nil }
+func (plainStore) ListAccounts() []string            { return nil }
+
+func TestDumperForwardsCapabilityOfWrappedStore(t *testing.T) {
+	memory := NewMemoryStore()
+	if err := memory.Set("acct", &oauth2.Token{AccessToken: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	observed := NewObservedStore(NewInstrumentedStore(memory, "memory", nil))
+	var events []string
+	observed.Observe(func(account, event string) { events = append(events, event) })
+
+	dumper, ok := Dumper(observed)
+	if !ok {
+		t.Fatal("Dumper reported no support for a wrapped memory store")
+	}
+	data, err := dumper.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dumper.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0] != "restore" {
+		t.Errorf("observer events = %v, want the restore to go through the wrapper", events)
+	}
+
+	if _, ok := Dumper(NewObservedStore(NewInstrumentedStore(plainStore{}, "plain", nil))); ok {
+		t.Error("Dumper reported support for a store without Dump")
+	}
+	if _, ok := Dumper(plainStore{}); ok {
+		t.Error("Dumper reported support for a plain store")
+	}
+}